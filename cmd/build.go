@@ -1,27 +1,41 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"path"
+	"time"
 
 	"github.com/gonstr/rig/pkg/fs"
 
-	"github.com/gonstr/rig/pkg/build"
+	"github.com/gonstr/rig/pkg/diff"
+	"github.com/gonstr/rig/pkg/rig"
+	"github.com/gonstr/rig/pkg/watch"
 	"github.com/spf13/cobra"
 )
 
 var fromStdin bool
 var values []string
 var stringValues []string
+var verify bool
+var allowEnv []string
+var watchChanges bool
+var printDiff bool
 
 func init() {
 	buildCmd.Flags().BoolVar(&fromStdin, "from-stdin", false, "build template from stdin")
 	buildCmd.Flags().StringArrayVar(&values, "value", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 	buildCmd.Flags().StringArrayVar(&stringValues, "string-value", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
 
+	buildCmd.Flags().BoolVar(&offline, "offline", false, "build from the template cache without fetching")
+	buildCmd.Flags().BoolVar(&verify, "verify", false, "refuse to build templates that are not signed by a trusted key")
+	buildCmd.Flags().StringSliceVar(&allowEnv, "allow-env", []string{}, "only allow templates to read these environment variables (can specify multiple or separate names with commas: NAME1,APP_*)")
+	buildCmd.Flags().BoolVar(&watchChanges, "watch", false, "rebuild the template whenever rig.yaml or local template files change")
+	buildCmd.Flags().BoolVar(&printDiff, "diff", false, "print a diff from the previous build instead of the full output in watch mode")
+
 	rootCmd.AddCommand(buildCmd)
 }
 
@@ -38,44 +52,191 @@ a rig.yaml file is expected. The template can also be passed to stdin if the
 Template values can be defined in rig.yaml or by --value or --string-value
 arguments. Values supplied in arguments supercede values in rig.yaml.
 
+Values can also be read from files listed under valueFiles in rig.yaml. Value
+files are merged in order on top of values in rig.yaml. Files encrypted with
+'rig secrets' are decrypted in memory:
+
+valueFiles:
+  - values.yaml
+  - secrets.yaml
+
+Values can reference other sources. References are resolved before rendering:
+
+ref+env://DB_HOST                 the environment variable DB_HOST
+ref+file://secret.txt             the contents of a file relative to rig.yaml
+ref+file://secrets.yaml#db.pass   a key of a yaml or json file
+ref+exec://pass show db           the output of a shell command
+
+Templates and rig.yaml can read environment variables. env and expandenv fail
+on unset variables, expandenv lists all of them. Use envOr or optionalEnv for
+variables that can be unset:
+
+{{ env "CLUSTER" }}
+{{ expandenv "${APP}-${CLUSTER}" }}
+{{ envOr "TAG" "latest" }}
+{{ optionalEnv "SUFFIX" }}
+
+Remote templates can only read environment variables listed in allowEnv in
+rig.yaml or with --allow-env. Local templates are sandboxed the same way if
+either is used. Names can be patterns:
+
+template:
+  url: https://github.com/gonstr/rig-templates//simple-app#simple-app/v1.0.0
+  allowEnv:
+    - CLUSTER
+    - APP_*
+
+Use --verify to refuse building templates that are not signed by a key listed
+in trustedKeys in rig.yaml or ~/.rig/config.yaml.
+
+Use --offline to build remote templates from the template cache without
+fetching. Offline mode can be made the default with offline: true in
+~/.rig/config.yaml. Templates pinned to a commit sha are never fetched if the
+commit is already cached.
+
+Objects built from rig.yaml can be patched with strategic merge patches or
+JSON patches. Patches are targeted by kind, name, namespace and labels and
+fail if they do not match any object. Patches can also be read from files
+relative to rig.yaml:
+
+patches:
+  - target:
+      kind: Deployment
+      name: my-app
+    patch:
+      spec:
+        template:
+          spec:
+            containers:
+              - name: sidecar
+                image: envoyproxy/envoy:v1.14
+  - target:
+      kind: Deployment
+    jsonPatch:
+      - op: add
+        path: /spec/template/spec/tolerations
+        value:
+          - key: dedicated
+            operator: Exists
+  - path: patches/resources.yaml
+
+Labels, annotations and a namespace can be added to all objects built from
+rig.yaml with commonLabels, commonAnnotations and namespace. Common labels are
+also added to pod templates and existing selectors. The namespace is only set
+on namespaced kinds. Custom resources are expected to be namespaced unless
+their kind is listed in clusterScopedKinds.
+
+NOTE: the selectors of deployments, stateful sets, daemon sets and jobs can
+not be changed once they are applied. Changing commonLabels of deployed
+objects fails unless commonLabelSelectors is set to false, which leaves all
+selectors as they are:
+
+commonLabels:
+  team: platform
+commonLabelSelectors: false
+commonAnnotations:
+  owner: platform@example.com
+namespace: my-app
+clusterScopedKinds:
+  - ClusterIssuer
+
+The output of templates built from rig.yaml can then be processed by
+postRender steps. Steps run in sequence. Commands receive the manifest on stdin and
+print the modified manifest to stdout. Commands that only check the manifest
+must print it unchanged. The built-in steps sort objects by kind and add labels
+to all objects:
+
+postRender:
+  - builtin: sort
+  - builtin: labels
+    labels:
+      team: platform
+  - name: validate
+    command: m=$(cat) && printf '%s\n' "$m" | kubeval --strict - >&2 && printf '%s\n' "$m"
+
+Use --watch to rebuild the template whenever rig.yaml or the files of a local
+template change. Render errors are printed without exiting. Add --diff to
+print a diff from the previous build instead of the full output.
+
 Example usage:
 
 rig build
 rig build --value deployment.tag=$(git rev-parse HEAD)
 rig build my/manifests/folder --value host=my-app.${CLUSTER}.example.com
 cat manifest.yaml | rig build --from-stdin --string-value port=8080
+rig build --watch --diff
 
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if fromStdin && watchChanges {
+			check(errors.New("invalid command: --watch can not be used with --from-stdin"))
+		}
+
+		if printDiff && !watchChanges {
+			check(errors.New("invalid command: --diff can only be used with --watch"))
+		}
+
+		opts := rig.Options{
+			SetValues:       values,
+			SetStringValues: stringValues,
+			Verify:          verify,
+			Offline:         offlineMode(cmd),
+			AllowEnv:        allowEnv,
+		}
+
 		if fromStdin {
 			bytes, err := ioutil.ReadAll(os.Stdin)
 			check(err)
 
-			output, err := build.FromString(string(bytes), nil, values, stringValues)
-			check(err)
+			opts.Template = string(bytes)
+		} else if len(args) > 0 {
+			opts.TemplatesPath = args[0]
+		} else if !fs.PathExists("rig.yaml") {
+			check(errors.New("invalid command: either supply a template path argument or run the command in a dir with a rig.yaml file"))
+		}
 
-			fmt.Println(output)
-		} else {
-			if len(args) > 0 {
-				output, err := build.FromTemplatesPath(args[0], nil, values, stringValues)
-				check(err)
+		if !watchChanges {
+			opts.Stdout = os.Stdout
 
-				fmt.Println(output)
-			} else {
-				wd, err := os.Getwd()
-				check(err)
+			_, err := rig.NewBuilder(opts).Render(context.Background())
+			check(err)
+			return
+		}
 
-				rigPath := path.Join(wd, "rig.yaml")
+		watchBuild(rig.NewBuilder(opts), watch.Interval, nil, os.Stdout, os.Stderr)
+	},
+}
 
-				if !fs.PathExists(rigPath) {
-					check(errors.New("invalid command: either supply a template path argument or run the command in a dir with a rig.yaml file"))
-				}
+// watchBuild renders on every change to the watched paths until stop is
+// closed. Output is written to stdout. Render errors are written to stderr
+// without exiting
+func watchBuild(builder *rig.Builder, interval time.Duration, stop <-chan struct{}, stdout io.Writer, stderr io.Writer) {
+	last, err := builder.Render(context.Background())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+	} else {
+		fmt.Fprintln(stdout, last)
+	}
+
+	fmt.Fprintln(stderr, "Watching for changes. Press Ctrl+C to stop")
+
+	watch.Poll(builder.WatchPaths, interval, stop, func() {
+		output, err := builder.Render(context.Background())
+		if err != nil {
+			fmt.Fprintf(stderr, "\n[%s] %s\n", time.Now().Format("15:04:05"), err)
+			return
+		}
 
-				output, err := build.FromRigFile(rigPath, values, stringValues)
-				check(err)
+		fmt.Fprintf(stderr, "\n[%s] Template rebuilt\n", time.Now().Format("15:04:05"))
 
-				fmt.Println(output)
-			}
+		if !printDiff {
+			fmt.Fprintln(stdout, output)
+		} else if d := diff.Unified(last+"\n", output+"\n", "previous", "current"); d != "" {
+			fmt.Fprint(stdout, d)
+		} else {
+			fmt.Fprintln(stderr, "No changes in output")
 		}
-	},
+
+		last = output
+	})
 }
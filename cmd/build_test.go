@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gonstr/rig/pkg/rig"
+)
+
+// output is a writer that can be read while it is written to
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// waitFor waits until the output contains str
+func waitFor(t *testing.T, o *output, str string) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !strings.Contains(o.String(), str) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %q in:\n%s", str, o.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchBuildDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	templatePath := path.Join(dir, "templates", "service.yaml")
+
+	// Files are replaced in one step so the watch never sees a partial write
+	write := func(content string) {
+		tmpPath := path.Join(dir, "service.yaml.tmp")
+
+		err := ioutil.WriteFile(tmpPath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.Rename(tmpPath, templatePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.MkdirAll(path.Dir(templatePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("kind: Service\nmetadata:\n  name: app\nport: 80")
+
+	defer func(diff bool) { printDiff = diff }(printDiff)
+	printDiff = true
+
+	var stdout, stderr output
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		watchBuild(rig.NewBuilder(rig.Options{TemplatesPath: path.Join(dir, "templates")}), 10*time.Millisecond, stop, &stdout, &stderr)
+		close(done)
+	}()
+
+	// The first build is printed in full
+	waitFor(t, &stderr, "Watching for changes")
+	waitFor(t, &stdout, "kind: Service\nmetadata:\n  name: app\nport: 80\n")
+
+	write("kind: Service\nmetadata:\n  name: app\nport: 8080")
+
+	waitFor(t, &stdout, "--- previous\n+++ current\n")
+	waitFor(t, &stdout, "-port: 80\n+port: 8080\n")
+
+	if strings.Count(stdout.String(), "name: app") != 2 {
+		t.Errorf("expected only the first build and the diff context to be printed, got:\n%s", stdout.String())
+	}
+
+	// Changes to the template that do not change the output are reported on
+	// stderr
+	write("kind: Service\nmetadata:\n  name: app\nport: 8080{{/* comment */}}")
+
+	waitFor(t, &stderr, "No changes in output")
+
+	// Render errors are printed without stopping the watch
+	write("kind: Service\nmetadata:\n  name: {{ .values.missing.name }}")
+
+	waitFor(t, &stderr, "missing")
+
+	write("kind: Service\nmetadata:\n  name: app\nport: 9090")
+
+	waitFor(t, &stdout, "-port: 8080\n+port: 9090\n")
+
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected watchBuild to return when stop is closed")
+	}
+}
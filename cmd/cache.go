@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/cache"
+	"github.com/gonstr/rig/pkg/config"
+)
+
+var days int
+
+func init() {
+	cachePruneCmd.Flags().IntVar(&days, "days", 30, "prune repositories not used in this many days")
+
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cacheCleanCmd)
+	cacheCmd.AddCommand(cacheDirCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the template cache",
+	Long: `Manage the template cache. Template repositories are cloned and template
+archives are downloaded to the cache dir which defaults to ~/.rig. The cache dir can be changed with the
+RIG_CACHE_DIR environment variable or with cacheDir in ~/.rig/config.yaml.
+	`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cached template repositories",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		repos, err := cache.List()
+		check(err)
+
+		cacheDir, err := config.CacheDir()
+		check(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tSIZE\tLAST USED")
+		for _, repo := range repos {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", relDir(cacheDir, repo.Dir), formatSize(repo.Size), repo.LastUsed.Format(time.RFC3339))
+		}
+		w.Flush()
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached template repositories that have not been used recently",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		repos, err := cache.Prune(time.Duration(days) * 24 * time.Hour)
+		printRemoved(repos)
+		check(err)
+	},
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove all cached template repositories",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		repos, err := cache.Clean()
+		printRemoved(repos)
+		check(err)
+	},
+}
+
+var cacheDirCmd = &cobra.Command{
+	Use:   "dir",
+	Short: "Print the template cache dir",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cacheDir, err := config.CacheDir()
+		check(err)
+
+		fmt.Println(cacheDir)
+	},
+}
+
+func printRemoved(repos []cache.Repo) {
+	cacheDir, err := config.CacheDir()
+	check(err)
+
+	var size int64
+	for _, repo := range repos {
+		fmt.Printf("Removed %s\n", relDir(cacheDir, repo.Dir))
+		size += repo.Size
+	}
+
+	fmt.Printf("Removed %d repositories, freed %s\n", len(repos), formatSize(size))
+}
+
+func relDir(base string, dir string) string {
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return dir
+	}
+	return rel
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
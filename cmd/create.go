@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/create"
+)
+
+var from string
+
+func init() {
+	createCmd.Flags().StringVar(&from, "from", "", "base the new template on an existing local template dir or remote template url")
+	createCmd.Flags().BoolVar(&offline, "offline", false, "use the template cache without fetching")
+	rootCmd.AddCommand(createCmd)
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a new template",
+	Long: `Create a new template in a directory with the given name. The template
+will contain a values.yaml file, a templates directory with a deployment,
+a service, an ingress and helpers, a rig-template.yaml metadata file and
+a values.schema.json stub.
+
+Use --from to base the new template on an existing local or remote template.
+
+Examples:
+
+rig create my-app
+rig create my-app --from ./templates/simple-app
+rig create my-app --from https://github.com/gonstr/rig-templates/simple-app#master
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+
+		if from != "" {
+			err = create.FromTemplate(args[0], from, offlineMode(cmd))
+		} else {
+			err = create.New(args[0])
+		}
+		check(err)
+
+		fmt.Printf("Template created in %s\n", args[0])
+	},
+}
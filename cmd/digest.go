@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/digest"
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+var update bool
+
+func init() {
+	digestCmd.Flags().BoolVarP(&update, "update", "u", false, "update the template digest in rig.yaml")
+	digestCmd.Flags().BoolVar(&offline, "offline", false, "use the template cache without fetching")
+	rootCmd.AddCommand(digestCmd)
+}
+
+var digestCmd = &cobra.Command{
+	Use:   "digest [path]",
+	Short: "Print or update a template digest",
+	Long: `Print the digest of a template.
+
+Template path can be supplied as the first argument. If no argument is supplied,
+the digest of the template in rig.yaml is printed. Use --update to pin the
+current digest of the template in rig.yaml. Builds fail if the template no
+longer matches the pinned digest.
+
+Examples:
+
+rig digest
+rig digest --update
+rig digest ./deploy/templates
+	`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			if update {
+				check(errors.New("invalid command: --update can not be used with a template path argument"))
+			}
+
+			d, err := digest.FromPath(args[0])
+			check(err)
+
+			fmt.Println(d)
+			return
+		}
+
+		wd, err := os.Getwd()
+		check(err)
+
+		rigPath := path.Join(wd, "rig.yaml")
+
+		if !fs.PathExists(rigPath) {
+			check(errors.New("invalid command: either supply a template path argument or run the command in a dir with a rig.yaml file"))
+		}
+
+		d, err := digest.FromRigFile(rigPath, offlineMode(cmd))
+		check(err)
+
+		if update {
+			err = digest.UpdateRigFile(rigPath, d)
+			check(err)
+		}
+
+		fmt.Println(d)
+	},
+}
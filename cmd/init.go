@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/install"
+)
+
+var templatePath string
+
+func init() {
+	initCmd.Flags().StringVar(&templatePath, "path", "", "path to a local template dir, relative to the current directory")
+	initCmd.Flags().BoolVarP(&force, "force", "f", false, "FORCE init even if a template has already been installed. This will overwrite rig.yaml")
+	initCmd.Flags().BoolVar(&verify, "verify", false, "refuse to install templates that are not signed by a trusted key")
+	rootCmd.AddCommand(initCmd)
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Install a local rig template to the current directory",
+	Long: `Install a rig template from a local directory to the current directory.
+This is useful when templates are vendored in the app repository. The
+template path and digest will be stored in rig.yaml together with the
+values from the template's values.yaml.
+
+Examples:
+
+rig init --path ./deploy/templates
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if templatePath == "" {
+			check(errors.New("invalid command: --path is required"))
+		}
+
+		meta, err := install.FromPath(templatePath, install.Options{Force: force, Verify: verify})
+		check(err)
+
+		if meta != nil {
+			printMetadata(meta)
+		}
+
+		fmt.Println("Template installed. Edit values in rig.yaml to your liking and run 'rig build' to build the template")
+	},
+}
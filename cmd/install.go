@@ -1,38 +1,124 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	rigcontext "github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/index"
 	"github.com/gonstr/rig/pkg/install"
+	"github.com/gonstr/rig/pkg/metadata"
 )
 
 var force bool
 
 func init() {
 	installCmd.Flags().BoolVarP(&force, "force", "f", false, "FORCE install even if a template has already been installed. This will overwrite rig.yaml")
+	installCmd.Flags().BoolVar(&offline, "offline", false, "install from the template cache without fetching")
+	installCmd.Flags().BoolVar(&verify, "verify", false, "refuse to install templates that are not signed by a trusted key")
 	rootCmd.AddCommand(installCmd)
 }
 
 var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install a remote rig template to the current directory",
-	Long: `Install a rig template from a remote github repository to the current
-directory. Template data will be stored in rig.yaml. Git branch/tag or commit
-can be defined as a fragment in the template url.
+	Long: `Install a rig template from a git repository or a template archive to the
+current directory. Template data will be stored in rig.yaml. Git branch/tag or
+commit can be defined as a fragment in the template url.
+
+Template urls are expected to be in the form https://host/owner/repo/path. For
+hosts with nested groups or other url layouts the repository and template path
+can be separated by a double slash, e.g. https://host/group/sub/repo.git//path.
+Local git repositories can be used with file urls or relative paths, e.g.
+file:///srv/templates.git//simple-app or ../templates.git//simple-app.
+Template archives created with 'rig package' can be installed from http urls
+or local paths ending with .tgz, or from oci registries with oci urls, e.g.
+oci://registry.example.com/templates/simple-app:1.2.0. The manifest digest of
+oci templates is pinned in rig.yaml.
+
+The values of the template are copied to rig.yaml. Templates with ref+env,
+ref+exec or ref+file values are refused since they would be resolved on every
+build.
+
+Templates in registered template indexes can be installed by name in the form
+<repository>/<template>[@<version>]. See 'rig repo --help'.
+
+Private repositories can be accessed with ssh urls or with access tokens.
+Tokens are read from the RIG_GIT_TOKEN_<HOST> environment variable, e.g.
+RIG_GIT_TOKEN_GITHUB_COM, or from ~/.rig/credentials.yaml:
+
+hosts:
+  github.com:
+    tokenEnv: GITHUB_TOKEN
+  gitlab.example.com:
+    username: deploy
+    token: <token>
+    sshKey: ~/.ssh/id_deploy
 
 Examples:
 
 rig install https://github.com/gonstr/rig-templates/simple-app
 rig install https://github.com/gonstr/rig-templates/simple-app#master
 rig install https://github.com/gonstr/rig-templates/simple-app#simple-app/v1.0.0
+rig install git@github.com:gonstr/rig-templates/simple-app#master
+rig install https://gitlab.com/group/subgroup/templates.git//simple-app#v1
+rig install file:///srv/templates.git//simple-app#v1
+rig install https://artifacts.example.com/templates/simple-app-1.2.0.tgz
+rig install ./dist/simple-app-1.2.0.tgz
+rig install oci://registry.example.com/templates/simple-app:1.2.0
+rig install myrepo/simple-app@1.0.0
 	`,
 	Args: cobra.RangeArgs(1, 1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := install.FromURL(args[0], force)
+		opts := install.Options{Force: force, Verify: verify, Offline: offlineMode(cmd)}
+
+		var meta *metadata.Metadata
+		var err error
+
+		if index.IsRef(args[0]) {
+			var tmpl rigcontext.Context
+			tmpl, err = index.Resolve(context.Background(), args[0], opts.Offline)
+			check(err)
+
+			meta, err = install.FromContext(tmpl, opts)
+		} else {
+			meta, err = install.FromURL(args[0], opts)
+		}
 		check(err)
 
+		if meta != nil {
+			printMetadata(meta)
+		}
+
 		fmt.Println("Template installed. Edit values in rig.yaml to your liking and run 'rig build' to build the template")
 	},
 }
+
+func printMetadata(meta *metadata.Metadata) {
+	fmt.Println(meta)
+
+	if len(meta.Maintainers) > 0 {
+		var maintainers []string
+		for _, m := range meta.Maintainers {
+			if m.Email != "" {
+				maintainers = append(maintainers, fmt.Sprintf("%s <%s>", m.Name, m.Email))
+			} else {
+				maintainers = append(maintainers, m.Name)
+			}
+		}
+		fmt.Printf("Maintainers: %s\n", strings.Join(maintainers, ", "))
+	}
+
+	if len(meta.Keywords) > 0 {
+		fmt.Printf("Keywords: %s\n", strings.Join(meta.Keywords, ", "))
+	}
+
+	if meta.RigVersion != "" {
+		fmt.Printf("Requires rig: %s\n", meta.RigVersion)
+	}
+
+	fmt.Println()
+}
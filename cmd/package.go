@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/archive"
+)
+
+var outDir string
+
+func init() {
+	packageCmd.Flags().StringVarP(&outDir, "out", "o", ".", "dir to write the template archive to")
+	rootCmd.AddCommand(packageCmd)
+}
+
+var packageCmd = &cobra.Command{
+	Use:   "package [path]",
+	Short: "Package a template to an archive",
+	Long: `Package a template dir to a versioned archive, <name>-<version>.tgz. The
+template must have a rig-template.yaml metadata file. The digest of the
+template files is stamped into the metadata file of the archive and the
+archive is verified against it whenever it is used.
+
+Archives can be published to any http server and installed with
+'rig install https://host/path/<name>-<version>.tgz' or from a local path.
+
+Examples:
+
+rig package ./templates/simple-app
+rig package ./templates/simple-app --out ./dist
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		check(err)
+
+		archivePath, digest, err := archive.Package(path.Join(wd, args[0]), path.Join(wd, outDir))
+		check(err)
+
+		fmt.Printf("Packaged %s\n%s\n", relDir(wd, archivePath), digest)
+	},
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/push"
+)
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+}
+
+var pushCmd = &cobra.Command{
+	Use:   "push [path] [url]",
+	Short: "Push a template to an oci registry",
+	Long: `Push a template archive or a template dir to an oci registry. Template dirs
+are packaged before they are pushed. The tag defaults to the template version.
+
+Templates in oci registries can be installed with 'rig install oci://...'. The
+manifest digest the tag points to is pinned in rig.yaml on install. Registries
+on localhost are accessed with http, all others with https. Credentials are
+configured in ~/.rig/credentials.yaml or with the RIG_GIT_TOKEN_<HOST>
+environment variable, see 'rig install --help'.
+
+Examples:
+
+rig push ./dist/simple-app-1.2.0.tgz oci://registry.example.com/templates/simple-app
+rig push ./templates/simple-app oci://localhost:5000/templates/simple-app:latest
+	`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		url, err := push.ToOCI(context.Background(), args[0], args[1])
+		check(err)
+
+		fmt.Printf("Pushed %s\n", url)
+	},
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/config"
+	"github.com/gonstr/rig/pkg/index"
+)
+
+func init() {
+	repoAddCmd.Flags().BoolVar(&offline, "offline", false, "use the template cache without fetching")
+
+	repoCmd.AddCommand(repoAddCmd)
+	repoCmd.AddCommand(repoListCmd)
+	repoCmd.AddCommand(repoRemoveCmd)
+	rootCmd.AddCommand(repoCmd)
+}
+
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Manage template indexes",
+	Long: `Manage template indexes. A template index is an index.yaml file listing
+template versions:
+
+templates:
+  simple-app:
+    - version: 1.0.0
+      description: A deployment with a service and an optional ingress
+      keywords:
+        - deployment
+      url: https://github.com/gonstr/rig-templates/simple-app#simple-app/v1.0.0
+
+Indexes can be local files or dirs, or stored in git repositories. Entry urls
+in local indexes that start with ./ or ../ are relative to the index file,
+e.g. ./dist/simple-app-1.0.0.tgz. Templates in registered indexes can be found
+with 'rig search' and installed with
+'rig install <repository>/<template>[@<version>]'.
+
+Examples:
+
+rig repo add gonstr https://github.com/gonstr/rig-templates//index.yaml#master
+rig repo add local ./templates/index.yaml
+	`,
+}
+
+var repoAddCmd = &cobra.Command{
+	Use:   "add [name] [url-or-path]",
+	Short: "Register a template index",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := index.AddRepository(context.Background(), args[0], args[1], offlineMode(cmd))
+		check(err)
+
+		fmt.Printf("Repository %s added\n", args[0])
+	},
+}
+
+var repoListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered template indexes",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		repos, err := config.LoadRepositories()
+		check(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tURL")
+		for _, repo := range repos.Repositories {
+			fmt.Fprintf(w, "%s\t%s\n", repo.Name, repo.URL)
+		}
+		w.Flush()
+	},
+}
+
+var repoRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Unregister a template index",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := index.RemoveRepository(args[0])
+		check(err)
+
+		fmt.Printf("Repository %s removed\n", args[0])
+	},
+}
@@ -5,8 +5,12 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/config"
 )
 
+var offline bool
+
 var rootCmd = &cobra.Command{
 	Use:   "rig",
 	Short: "Rig is a Kubernetes manifest preprocessor and templating tool",
@@ -22,6 +26,19 @@ func Execute() {
 	check(err)
 }
 
+// offlineMode returns true if the --offline flag is set. The user config
+// decides if the flag is not supplied
+func offlineMode(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("offline") {
+		return offline
+	}
+
+	cfg, err := config.Load()
+	check(err)
+
+	return cfg.Offline
+}
+
 func check(err error) {
 	if err != nil {
 		fmt.Println(err)
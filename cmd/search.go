@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/index"
+)
+
+func init() {
+	searchCmd.Flags().BoolVar(&offline, "offline", false, "use the template cache without fetching")
+	rootCmd.AddCommand(searchCmd)
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search [term]",
+	Short: "Search registered template indexes",
+	Long: `Search the registered template indexes for templates whose name,
+description or keywords contain a term. The latest version of each matching
+template is listed. All templates are listed if no term is supplied. Indexes
+that can not be loaded are reported and skipped.
+
+Examples:
+
+rig search
+rig search ingress
+	`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		term := ""
+		if len(args) > 0 {
+			term = args[0]
+		}
+
+		results, skipped, err := index.Search(context.Background(), term, offlineMode(cmd))
+		check(err)
+
+		for _, err := range skipped {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tDESCRIPTION")
+		for _, result := range results {
+			fmt.Fprintf(w, "%s/%s\t%s\t%s\n", result.Repository, result.Name, result.Version, result.Description)
+		}
+		w.Flush()
+	},
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/secrets"
+)
+
+var recipients []string
+var sopsFormat bool
+
+func init() {
+	secretsEncryptCmd.Flags().StringArrayVarP(&recipients, "recipient", "r", []string{}, "also encrypt to an age recipient and add it to .age-recipients (can specify multiple)")
+	secretsEncryptCmd.Flags().BoolVar(&sopsFormat, "sops", false, "encrypt values in the SOPS format")
+	secretsEditCmd.Flags().StringArrayVarP(&recipients, "recipient", "r", []string{}, "also encrypt to an age recipient and add it to .age-recipients (can specify multiple)")
+	secretsEditCmd.Flags().BoolVar(&sopsFormat, "sops", false, "create new files in the SOPS format")
+	secretsRekeyCmd.Flags().StringArrayVarP(&recipients, "recipient", "r", []string{}, "also encrypt to an age recipient and add it to .age-recipients (can specify multiple)")
+
+	secretsCmd.AddCommand(secretsKeygenCmd)
+	secretsCmd.AddCommand(secretsEncryptCmd)
+	secretsCmd.AddCommand(secretsDecryptCmd)
+	secretsCmd.AddCommand(secretsEditCmd)
+	secretsCmd.AddCommand(secretsRekeyCmd)
+	rootCmd.AddCommand(secretsCmd)
+}
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage encrypted values files",
+	Long: `Manage age encrypted values files. Values files are listed under
+valueFiles in rig.yaml and encrypted files are decrypted in memory when
+building:
+
+valueFiles:
+  - values.yaml
+  - secrets.yaml
+
+The age key is read from the RIG_AGE_KEY environment variable or from the
+key file, RIG_AGE_KEY_FILE or ~/.rig/age.key.
+
+Files are encrypted to the recipients listed in .age-recipients next to them.
+Recipients supplied with --recipient are added to the list. If there is no
+list, files are encrypted to the recipient of the local key and the list is
+created. Run 'rig secrets rekey' after removing recipients from the list.
+
+Files are encrypted with age by default and can be decrypted with the age cli.
+Use --sops to encrypt values in the SOPS format instead. SOPS files with age
+recipients are decrypted and edited in place.
+
+Examples:
+
+rig secrets keygen
+rig secrets encrypt secrets.yaml
+rig secrets encrypt secrets.yaml --recipient age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+rig secrets encrypt secrets.yaml --sops
+rig secrets edit secrets.yaml
+rig secrets decrypt secrets.yaml
+rig secrets rekey secrets.yaml
+	`,
+}
+
+var secretsKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an age key",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		identity, keyFile, err := secrets.Keygen()
+		check(err)
+
+		fmt.Printf("Key written to %s\nPublic key: %s\n", keyFile, identity.Recipient())
+	},
+}
+
+var secretsEncryptCmd = &cobra.Command{
+	Use:   "encrypt [path]",
+	Short: "Encrypt a values file in place",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		check(err)
+
+		filePath := path.Join(wd, args[0])
+
+		r, err := secrets.Recipients(filePath, recipients)
+		check(err)
+
+		err = secrets.EncryptFile(filePath, r, sopsFormat)
+		check(err)
+
+		fmt.Printf("Encrypted %s\n", args[0])
+	},
+}
+
+var secretsDecryptCmd = &cobra.Command{
+	Use:   "decrypt [path]",
+	Short: "Print a decrypted values file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		check(err)
+
+		bytes, err := secrets.ReadFile(path.Join(wd, args[0]))
+		check(err)
+
+		fmt.Print(string(bytes))
+	},
+}
+
+var secretsEditCmd = &cobra.Command{
+	Use:   "edit [path]",
+	Short: "Edit an encrypted values file in $EDITOR",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		check(err)
+
+		filePath := path.Join(wd, args[0])
+
+		r, err := secrets.Recipients(filePath, recipients)
+		check(err)
+
+		err = secrets.Edit(filePath, r, sopsFormat)
+		check(err)
+	},
+}
+
+var secretsRekeyCmd = &cobra.Command{
+	Use:   "rekey [path]",
+	Short: "Encrypt a values file again to the current recipients",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wd, err := os.Getwd()
+		check(err)
+
+		filePath := path.Join(wd, args[0])
+
+		r, err := secrets.Recipients(filePath, recipients)
+		check(err)
+
+		err = secrets.Rekey(filePath, r)
+		check(err)
+
+		fmt.Printf("Encrypted %s to %d recipients\n", args[0], len(r))
+	},
+}
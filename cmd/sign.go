@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/sign"
+)
+
+var keyPath string
+
+func init() {
+	signCmd.Flags().StringVar(&keyPath, "key", "", "path to an unencrypted SSH private key, e.g. ~/.ssh/id_ed25519")
+	rootCmd.AddCommand(signCmd)
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign [path]",
+	Short: "Sign a template",
+	Long: `Sign a template with an SSH private key. A detached signature file,
+rig-template.sig, is written to the template dir. The signature covers every
+file in the template dir, including values and patches but not hidden dirs like
+.git, and must be recreated whenever any of them change. Signed templates stay
+signed when they are packaged with 'rig package'.
+
+Templates are verified with 'rig build --verify' or 'rig install --verify'
+against the public keys listed in trustedKeys in the template section of
+rig.yaml or in ~/.rig/config.yaml. Templates in remote repositories can also
+be verified by SSH signed git tags instead of a signature file.
+
+Examples:
+
+ssh-keygen -t ed25519 -f template-key
+rig sign ./templates/simple-app --key template-key
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if keyPath == "" {
+			check(errors.New("invalid command: --key is required"))
+		}
+
+		digest, err := sign.Template(args[0], keyPath)
+		check(err)
+
+		fmt.Printf("Signed %s\n", digest)
+	},
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+
+	"github.com/gonstr/rig/pkg/version"
 )
 
 func init() {
@@ -15,6 +17,6 @@ var versionCmd = &cobra.Command{
 	Short: "Print the version number of rig",
 	Long:  `All software has versions. This is Rig's.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Rig v0.3.4")
+		fmt.Printf("Rig v%s\n", version.Version)
 	},
 }
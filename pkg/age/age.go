@@ -0,0 +1,563 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypt and Decrypt implement the age v1 file format
+// (https://age-encryption.org/v1) with X25519 recipients. Encrypted files are
+// armored so they can be committed and diffed as text
+
+const (
+	intro        = "age-encryption.org/v1\n"
+	x25519Label  = "age-encryption.org/v1/X25519"
+	armorBegin   = "-----BEGIN AGE ENCRYPTED FILE-----"
+	armorEnd     = "-----END AGE ENCRYPTED FILE-----"
+	columns      = 64
+	chunkSize    = 64 * 1024
+	fileKeySize  = 16
+	tagSize      = 16
+	streamNonce  = 16
+	identityHRP  = "AGE-SECRET-KEY-"
+	recipientHRP = "age"
+)
+
+var b64 = base64.RawStdEncoding.Strict()
+
+// Identity is an X25519 age identity, i.e. a private key
+type Identity struct {
+	secret [32]byte
+	public [32]byte
+}
+
+// Recipient is an X25519 age recipient, i.e. a public key
+type Recipient struct {
+	public [32]byte
+}
+
+// GenerateIdentity returns a new random identity
+func GenerateIdentity() (*Identity, error) {
+	var secret [32]byte
+
+	_, err := rand.Read(secret[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return newIdentity(secret), nil
+}
+
+func newIdentity(secret [32]byte) *Identity {
+	i := &Identity{secret: secret}
+	curve25519.ScalarBaseMult(&i.public, &i.secret)
+	return i
+}
+
+// ParseIdentity parses an identity in the AGE-SECRET-KEY-1... format
+func ParseIdentity(s string) (*Identity, error) {
+	hrp, data, err := decodeBech32(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid age identity: %s", err)
+	}
+
+	if hrp != strings.ToLower(identityHRP) || len(data) != 32 {
+		return nil, errors.New("Invalid age identity: not an X25519 identity")
+	}
+
+	var secret [32]byte
+	copy(secret[:], data)
+
+	return newIdentity(secret), nil
+}
+
+// ParseIdentities parses an identity file. Empty lines and lines starting with
+// # are ignored
+func ParseIdentities(data []byte) ([]*Identity, error) {
+	var identities []*Identity
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		identity, err := ParseIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// String returns the identity in the AGE-SECRET-KEY-1... format
+func (i *Identity) String() string {
+	s, _ := encodeBech32(identityHRP, i.secret[:])
+	return s
+}
+
+// Recipient returns the recipient of an identity
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{public: i.public}
+}
+
+// ParseRecipient parses a recipient in the age1... format
+func ParseRecipient(s string) (*Recipient, error) {
+	hrp, data, err := decodeBech32(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid age recipient %s: %s", s, err)
+	}
+
+	if hrp != recipientHRP || len(data) != 32 {
+		return nil, fmt.Errorf("Invalid age recipient %s: not an X25519 recipient", s)
+	}
+
+	r := &Recipient{}
+	copy(r.public[:], data)
+
+	return r, nil
+}
+
+// String returns the recipient in the age1... format
+func (r *Recipient) String() string {
+	s, _ := encodeBech32(recipientHRP, r.public[:])
+	return s
+}
+
+// IsEncrypted returns true if data is an armored or binary age file
+func IsEncrypted(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return bytes.HasPrefix(data, []byte(armorBegin)) || bytes.HasPrefix(data, []byte(intro))
+}
+
+type stanza struct {
+	args []string
+	body []byte
+}
+
+// Encrypt encrypts plaintext to recipients and returns an armored age file
+func Encrypt(plaintext []byte, recipients ...*Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("No age recipients")
+	}
+
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(intro)
+
+	for _, r := range recipients {
+		s, err := r.wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+
+		writeStanza(&header, s)
+	}
+
+	header.WriteString("---")
+
+	mac := headerMAC(fileKey, header.Bytes())
+	fmt.Fprintf(&header, " %s\n", b64.EncodeToString(mac))
+
+	nonce := make([]byte, streamNonce)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	payload, err := seal(streamKey(fileKey, nonce), plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	data := append(header.Bytes(), nonce...)
+	data = append(data, payload...)
+
+	return armor(data), nil
+}
+
+// Decrypt decrypts an armored or binary age file with one of the identities
+func Decrypt(data []byte, identities ...*Identity) ([]byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armorBegin)) {
+		var err error
+		data, err = unarmor(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stanzas, headerNoMAC, mac, payload, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+
+	for _, s := range stanzas {
+		if len(s.args) == 0 || s.args[0] != "X25519" {
+			continue
+		}
+
+		for _, identity := range identities {
+			fileKey, err = identity.unwrap(s)
+			if err != nil {
+				return nil, err
+			}
+			if fileKey != nil {
+				break
+			}
+		}
+
+		if fileKey != nil {
+			break
+		}
+	}
+
+	if fileKey == nil {
+		return nil, errors.New("No age identity matches any of the recipients of the file")
+	}
+
+	if !hmac.Equal(headerMAC(fileKey, headerNoMAC), mac) {
+		return nil, errors.New("Invalid age header: bad MAC")
+	}
+
+	if len(payload) < streamNonce {
+		return nil, errors.New("Invalid age file: payload is too short")
+	}
+
+	return open(streamKey(fileKey, payload[:streamNonce]), payload[streamNonce:])
+}
+
+// wrap wraps a file key for a recipient
+func (r *Recipient) wrap(fileKey []byte) (*stanza, error) {
+	var ephemeral, share, shared [32]byte
+
+	if _, err := rand.Read(ephemeral[:]); err != nil {
+		return nil, err
+	}
+
+	curve25519.ScalarBaseMult(&share, &ephemeral)
+	curve25519.ScalarMult(&shared, &ephemeral, &r.public)
+
+	salt := append(share[:], r.public[:]...)
+
+	body, err := aeadSeal(hkdfKey(shared[:], salt, x25519Label), fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stanza{args: []string{"X25519", b64.EncodeToString(share[:])}, body: body}, nil
+}
+
+// unwrap unwraps the file key of a stanza. A nil key is returned if the stanza
+// is not for the identity
+func (i *Identity) unwrap(s stanza) ([]byte, error) {
+	if len(s.args) != 2 {
+		return nil, errors.New("Invalid age X25519 stanza")
+	}
+
+	share, err := b64.DecodeString(s.args[1])
+	if err != nil || len(share) != 32 {
+		return nil, errors.New("Invalid age X25519 stanza")
+	}
+
+	if len(s.body) != fileKeySize+tagSize {
+		return nil, errors.New("Invalid age X25519 stanza")
+	}
+
+	var theirShare, shared [32]byte
+	copy(theirShare[:], share)
+
+	curve25519.ScalarMult(&shared, &i.secret, &theirShare)
+
+	if subtle.ConstantTimeCompare(shared[:], make([]byte, 32)) == 1 {
+		return nil, errors.New("Invalid age X25519 stanza")
+	}
+
+	salt := append(theirShare[:], i.public[:]...)
+
+	aead, err := chacha20poly1305.New(hkdfKey(shared[:], salt, x25519Label))
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), s.body, nil)
+	if err != nil {
+		return nil, nil
+	}
+
+	return fileKey, nil
+}
+
+func writeStanza(w *bytes.Buffer, s *stanza) {
+	fmt.Fprintf(w, "-> %s\n", strings.Join(s.args, " "))
+
+	body := b64.EncodeToString(s.body)
+	for len(body) >= columns {
+		fmt.Fprintf(w, "%s\n", body[:columns])
+		body = body[columns:]
+	}
+
+	fmt.Fprintf(w, "%s\n", body)
+}
+
+// parseHeader parses the header of a binary age file. The stanzas, the header
+// up to the MAC, the MAC and the payload are returned
+func parseHeader(data []byte) ([]stanza, []byte, []byte, []byte, error) {
+	invalid := errors.New("Invalid age header")
+
+	if !bytes.HasPrefix(data, []byte(intro)) {
+		return nil, nil, nil, nil, errors.New("Not an age encrypted file")
+	}
+
+	rest := data[len(intro):]
+
+	next := func() (string, bool) {
+		i := bytes.IndexByte(rest, '\n')
+		if i == -1 {
+			return "", false
+		}
+		line := string(rest[:i])
+		rest = rest[i+1:]
+		return line, true
+	}
+
+	var stanzas []stanza
+
+	for {
+		line, ok := next()
+		if !ok {
+			return nil, nil, nil, nil, invalid
+		}
+
+		if strings.HasPrefix(line, "--- ") {
+			mac, err := b64.DecodeString(line[4:])
+			if err != nil {
+				return nil, nil, nil, nil, invalid
+			}
+
+			headerNoMAC := data[:len(data)-len(rest)-len(line)-1+3]
+
+			return stanzas, headerNoMAC, mac, rest, nil
+		}
+
+		if !strings.HasPrefix(line, "-> ") {
+			return nil, nil, nil, nil, invalid
+		}
+
+		s := stanza{args: strings.Split(line[3:], " ")}
+
+		for _, arg := range s.args {
+			if !isArg(arg) {
+				return nil, nil, nil, nil, invalid
+			}
+		}
+
+		for {
+			bodyLine, ok := next()
+			if !ok || len(bodyLine) > columns {
+				return nil, nil, nil, nil, invalid
+			}
+
+			decoded, err := b64.DecodeString(bodyLine)
+			if err != nil {
+				return nil, nil, nil, nil, invalid
+			}
+
+			s.body = append(s.body, decoded...)
+
+			if len(bodyLine) < columns {
+				break
+			}
+		}
+
+		stanzas = append(stanzas, s)
+	}
+}
+
+// isArg returns true if arg is a valid stanza argument, a non-empty string of
+// printable ascii characters
+func isArg(arg string) bool {
+	for _, c := range arg {
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+
+	return arg != ""
+}
+
+func headerMAC(fileKey []byte, header []byte) []byte {
+	h := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	h.Write(header)
+	return h.Sum(nil)
+}
+
+func streamKey(fileKey []byte, nonce []byte) []byte {
+	return hkdfKey(fileKey, nonce, "payload")
+}
+
+func hkdfKey(secret []byte, salt []byte, info string) []byte {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func aeadSeal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), plaintext, nil), nil
+}
+
+// chunkNonce returns the STREAM nonce of a chunk
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for i := 10; i >= 3; i-- {
+		nonce[i] = byte(counter)
+		counter >>= 8
+	}
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// seal encrypts a payload in 64 KiB chunks
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	var counter uint64
+
+	for {
+		n := len(plaintext)
+		if n > chunkSize {
+			n = chunkSize
+		}
+
+		last := n == len(plaintext)
+		out = aead.Seal(out, chunkNonce(counter, last), plaintext[:n], nil)
+		plaintext = plaintext[n:]
+
+		if last {
+			return out, nil
+		}
+
+		counter++
+	}
+}
+
+// open decrypts a payload encrypted in 64 KiB chunks
+func open(key []byte, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	var counter uint64
+
+	for {
+		n := len(ciphertext)
+		if n > chunkSize+aead.Overhead() {
+			n = chunkSize + aead.Overhead()
+		}
+
+		last := n == len(ciphertext)
+
+		out, err = aead.Open(out, chunkNonce(counter, last), ciphertext[:n], nil)
+		if err != nil {
+			return nil, errors.New("Invalid age payload: decryption failed")
+		}
+
+		if last && n == aead.Overhead() && counter > 0 {
+			return nil, errors.New("Invalid age payload: empty final chunk")
+		}
+
+		ciphertext = ciphertext[n:]
+
+		if last {
+			return out, nil
+		}
+
+		counter++
+	}
+}
+
+func armor(data []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(armorBegin + "\n")
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > columns {
+		buf.WriteString(encoded[:columns] + "\n")
+		encoded = encoded[columns:]
+	}
+
+	buf.WriteString(encoded + "\n")
+	buf.WriteString(armorEnd + "\n")
+
+	return buf.Bytes()
+}
+
+// unarmor decodes an armored file. Whitespace is allowed around the armor and
+// lines can end with CRLF, otherwise the armor has to be canonical
+func unarmor(data []byte) ([]byte, error) {
+	invalid := errors.New("Invalid age armor")
+
+	str := strings.Replace(strings.TrimSpace(string(data)), "\r\n", "\n", -1)
+	lines := strings.Split(str, "\n")
+
+	if len(lines) < 3 || lines[0] != armorBegin || lines[len(lines)-1] != armorEnd {
+		return nil, invalid
+	}
+
+	lines = lines[1 : len(lines)-1]
+
+	for i, line := range lines {
+		if len(line) > columns || i < len(lines)-1 && len(line) != columns || len(line) == 0 {
+			return nil, invalid
+		}
+	}
+
+	decoded, err := base64.StdEncoding.Strict().DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid age armor: %s", err)
+	}
+
+	return decoded, nil
+}
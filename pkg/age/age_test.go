@@ -0,0 +1,152 @@
+package age
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	alice, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eve, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := make([]byte, 3*chunkSize+7)
+	_, err = rand.Read(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, plaintext := range [][]byte{nil, []byte("db:\n  password: pw\n"), large[:chunkSize], large} {
+		encrypted, err := Encrypt(plaintext, alice.Recipient(), bob.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !IsEncrypted(encrypted) {
+			t.Fatal("expected encrypted file to be detected")
+		}
+
+		for _, identity := range []*Identity{alice, bob} {
+			decrypted, err := Decrypt(encrypted, identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("expected %d decrypted bytes to match the plaintext", len(plaintext))
+			}
+		}
+
+		_, err = Decrypt(encrypted, eve)
+		if err == nil {
+			t.Error("expected decrypting with another identity to fail")
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseIdentity(identity.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.String() != identity.String() {
+		t.Errorf("expected %s, got %s", identity, parsed)
+	}
+
+	recipient, err := ParseRecipient(identity.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recipient.String() != identity.Recipient().String() {
+		t.Errorf("expected %s, got %s", identity.Recipient(), recipient)
+	}
+
+	_, err = ParseRecipient(strings.ToUpper(recipient.String()[:10]) + recipient.String()[10:])
+	if err == nil {
+		t.Error("expected mixed case recipient to fail")
+	}
+}
+
+// TestVectors decrypts the X25519 test vectors of the age reference test suite
+// (https://github.com/C2SP/CCTV/tree/main/age). Vectors for passphrase
+// encryption are left out
+func TestVectors(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		t.Run(file.Name(), func(t *testing.T) {
+			data, err := ioutil.ReadFile(path.Join("testdata", file.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			i := bytes.Index(data, []byte("\n\n"))
+			header, body := string(data[:i]), data[i+2:]
+
+			var expect, payload string
+			var identities []*Identity
+
+			for _, line := range strings.Split(header, "\n") {
+				parts := strings.SplitN(line, ": ", 2)
+
+				switch parts[0] {
+				case "expect":
+					expect = parts[1]
+				case "payload":
+					payload = parts[1]
+				case "identity":
+					identity, err := ParseIdentity(parts[1])
+					if err != nil {
+						t.Fatal(err)
+					}
+					identities = append(identities, identity)
+				}
+			}
+
+			plaintext, err := Decrypt(body, identities...)
+
+			if expect != "success" {
+				if err == nil {
+					t.Errorf("expected %s", expect)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sum := sha256.Sum256(plaintext)
+			if hex.EncodeToString(sum[:]) != payload {
+				t.Error("expected payload to match")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package age
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// bech32 encoding as used by age keys, see BIP 173. Unlike BIP 173 there is
+// no length limit
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	h := []byte(strings.ToLower(hrp))
+	var ret []byte
+	for _, c := range h {
+		ret = append(ret, c>>5)
+	}
+	ret = append(ret, 0)
+	for _, c := range h {
+		ret = append(ret, c&31)
+	}
+	return ret
+}
+
+// convertBits regroups bytes of fromBits bits to bytes of toBits bits
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var ret []byte
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := byte(1<<toBits - 1)
+	for _, value := range data {
+		if value>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits)&maxv)
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits))&maxv)
+		}
+	} else if bits >= fromBits {
+		return nil, errors.New("illegal zero padding")
+	} else if byte(acc<<(toBits-bits))&maxv != 0 {
+		return nil, errors.New("non-zero padding")
+	}
+	return ret, nil
+}
+
+// encodeBech32 encodes data with a human readable part. The result is lower
+// case if the hrp is lower case and upper case otherwise
+func encodeBech32(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	lower := strings.ToLower(hrp)
+
+	values = append(hrpExpand(lower), values...)
+	mod := polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(lower)
+	sb.WriteByte('1')
+	for _, v := range values[len(hrpExpand(lower)):] {
+		sb.WriteByte(charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(charset[(mod>>uint(5*(5-i)))&31])
+	}
+
+	if hrp != lower {
+		return strings.ToUpper(sb.String()), nil
+	}
+
+	return sb.String(), nil
+}
+
+// decodeBech32 decodes a bech32 string to its human readable part and data
+func decodeBech32(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("separator '1' at invalid position")
+	}
+
+	hrp := s[:pos]
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			return "", nil, fmt.Errorf("invalid character in human-readable part: %q", c)
+		}
+	}
+
+	var values []byte
+	for _, c := range s[pos+1:] {
+		i := strings.IndexRune(charset, c)
+		if i == -1 {
+			return "", nil, fmt.Errorf("invalid character in data part: %q", c)
+		}
+		values = append(values, byte(i))
+	}
+
+	if polymod(append(hrpExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return hrp, data, nil
+}
@@ -0,0 +1,291 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gonstr/rig/pkg/fs"
+	"github.com/gonstr/rig/pkg/metadata"
+)
+
+var digestLine = regexp.MustCompile(`(?m)^digest:.*\n?`)
+
+// Archives are downloaded from remote hosts. Extracting is refused for
+// entries and archives larger than these limits
+var (
+	maxEntrySize int64 = 10 << 20
+	maxSize      int64 = 100 << 20
+)
+
+// Package writes a template dir to a versioned archive, <name>-<version>.tgz,
+// in outDir. The digest of the template files is stamped into the metadata
+// file of the archive. The archive path and the digest are returned
+func Package(templateDir string, outDir string) (string, string, error) {
+	meta, err := metadata.FromDir(templateDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	if meta == nil {
+		return "", "", fmt.Errorf("%s is not a template: %s is required to package it", templateDir, metadata.FileName)
+	}
+
+	if !fs.PathExists(path.Join(templateDir, "templates")) {
+		return "", "", fmt.Errorf("%s is not a template: templates dir is missing", templateDir)
+	}
+
+	digest, err := fs.DirectoryDigest(path.Join(templateDir, "templates"))
+	if err != nil {
+		return "", "", err
+	}
+
+	err = fs.EnsureDir(outDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	archivePath := path.Join(outDir, fmt.Sprintf("%s-%s.tgz", meta.Name, meta.Version))
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = write(file, templateDir, archivePath, meta.Name, digest)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(archivePath)
+		return "", "", err
+	}
+
+	return archivePath, digest, nil
+}
+
+// write writes the files of a template dir to a gzipped tar stream. Files are
+// stored below a single dir named after the template. The archive itself is
+// skipped in case it is written to the template dir
+func write(w io.Writer, templateDir string, archivePath string, name string, digest string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := filepath.Walk(templateDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(templateDir, filePath)
+		if err != nil {
+			return err
+		}
+
+		if relPath == "." || filePath == filepath.Clean(archivePath) {
+			return nil
+		}
+
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("Unable to package %s: symlinks are not supported", relPath)
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = path.Join(name, filepath.ToSlash(relPath))
+		header.Uname = ""
+		header.Gname = ""
+		header.Uid = 0
+		header.Gid = 0
+
+		if info.IsDir() {
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+
+		bytes, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		if relPath == metadata.FileName {
+			bytes = stamp(bytes, digest)
+		}
+
+		header.Size = int64(len(bytes))
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = tw.Write(bytes)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// stamp sets the digest of a metadata file
+func stamp(bytes []byte, digest string) []byte {
+	bytes = Unstamp(bytes)
+
+	if len(bytes) > 0 && bytes[len(bytes)-1] != '\n' {
+		bytes = append(bytes, '\n')
+	}
+
+	return append(bytes, []byte(fmt.Sprintf("digest: %s\n", digest))...)
+}
+
+// Unstamp removes the digest stamped into a metadata file by Package
+func Unstamp(bytes []byte) []byte {
+	return digestLine.ReplaceAll(bytes, nil)
+}
+
+// Extract extracts a template archive to targetDir and returns the template
+// dir. If the archive contains a single dir, that dir is the template dir. The
+// template files are verified against the digest stamped into the archive.
+// Archives without a stamped digest are refused
+func Extract(archivePath string, targetDir string) (string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	err = read(file, targetDir)
+	if err != nil {
+		return "", fmt.Errorf("Unable to extract %s: %s", archivePath, err)
+	}
+
+	templateDir := targetDir
+
+	files, err := ioutil.ReadDir(targetDir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 1 && files[0].IsDir() {
+		templateDir = path.Join(targetDir, files[0].Name())
+	}
+
+	meta, err := metadata.FromDir(templateDir)
+	if err != nil {
+		return "", err
+	}
+
+	if meta == nil {
+		return "", fmt.Errorf("%s is not a template archive: %s is missing", archivePath, metadata.FileName)
+	}
+
+	if meta.Digest == "" {
+		return "", fmt.Errorf("%s has no digest: package it with rig package", archivePath)
+	}
+
+	_, ok, err := fs.VerifyDirectoryDigest(path.Join(templateDir, "templates"), meta.Digest)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%s is corrupt: template files do not match the packaged digest", archivePath)
+	}
+
+	return templateDir, nil
+}
+
+// read extracts a gzipped tar stream to a dir. Only regular files and dirs
+// are extracted, entries must stay within the dir and stay below the size
+// limits
+func read(r io.Reader, targetDir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gr)
+
+	var size int64
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file path %s", header.Name)
+		}
+
+		filePath := filepath.Join(targetDir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(filePath, 0755)
+		case tar.TypeReg:
+			size += header.Size
+			if header.Size > maxEntrySize {
+				return fmt.Errorf("%s is larger than %d bytes", header.Name, maxEntrySize)
+			}
+			if size > maxSize {
+				return fmt.Errorf("archive is larger than %d bytes", maxSize)
+			}
+			err = extractFile(io.LimitReader(tr, header.Size), filePath, os.FileMode(header.Mode))
+		default:
+			err = fmt.Errorf("unsupported file type of %s", header.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, filePath string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,196 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// writeArchive writes a tgz with the given files to dir and returns its path
+func writeArchive(t *testing.T, dir string, files map[string]string) string {
+	archivePath := path.Join(dir, "template.tgz")
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	gw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range files {
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tw.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = gw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+func TestPackageExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	templateDir := path.Join(dir, "simple-app")
+
+	err = os.MkdirAll(path.Join(templateDir, "templates"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"rig-template.yaml":      "name: simple-app\nversion: 1.0.0\n",
+		"templates/service.yaml": "kind: Service\n",
+	} {
+		err = ioutil.WriteFile(path.Join(templateDir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath, _, err := Package(templateDir, path.Join(dir, "dist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extractedDir, err := Extract(archivePath, path.Join(dir, "extracted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bytes, err := ioutil.ReadFile(path.Join(extractedDir, "templates", "service.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bytes) != "kind: Service\n" {
+		t.Errorf("Unexpected service.yaml: %q", bytes)
+	}
+}
+
+func TestExtractInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "no metadata",
+			files: map[string]string{"app/templates/service.yaml": "kind: Service\n"},
+			err:   "rig-template.yaml is missing",
+		},
+		{
+			name: "no digest",
+			files: map[string]string{
+				"app/rig-template.yaml":      "name: app\nversion: 1.0.0\n",
+				"app/templates/service.yaml": "kind: Service\n",
+			},
+			err: "has no digest",
+		},
+		{
+			name: "wrong digest",
+			files: map[string]string{
+				"app/rig-template.yaml":      "name: app\nversion: 1.0.0\ndigest: sha256v2:0000\n",
+				"app/templates/service.yaml": "kind: Service\n",
+			},
+			err: "is corrupt",
+		},
+		{
+			name:  "path traversal",
+			files: map[string]string{"../app/rig-template.yaml": "name: app\n"},
+			err:   "invalid file path",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "rig-archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			archivePath := writeArchive(t, dir, test.files)
+
+			_, err = Extract(archivePath, path.Join(dir, "extracted"))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestExtractSizeLimits(t *testing.T) {
+	defer func(entry, total int64) {
+		maxEntrySize = entry
+		maxSize = total
+	}(maxEntrySize, maxSize)
+
+	maxEntrySize = 10
+	maxSize = 15
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "entry",
+			files: map[string]string{"app/templates/a.yaml": "kind: Service\n"},
+			err:   "app/templates/a.yaml is larger than 10 bytes",
+		},
+		{
+			name: "archive",
+			files: map[string]string{
+				"app/templates/a.yaml": "kind: A\n",
+				"app/templates/b.yaml": "kind: B\n",
+			},
+			err: "archive is larger than 15 bytes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "rig-archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			archivePath := writeArchive(t, dir, test.files)
+
+			_, err = Extract(archivePath, path.Join(dir, "extracted"))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
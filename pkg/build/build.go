@@ -1,28 +1,88 @@
 package build
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"path"
 	"regexp"
 	"strings"
 
-	"github.com/gonstr/rig/pkg/context"
+	rigcontext "github.com/gonstr/rig/pkg/context"
 	"github.com/gonstr/rig/pkg/engine"
+	"github.com/gonstr/rig/pkg/fetch"
 	"github.com/gonstr/rig/pkg/fs"
-	"github.com/gonstr/rig/pkg/git"
+	"github.com/gonstr/rig/pkg/metadata"
+	"github.com/gonstr/rig/pkg/patch"
+	"github.com/gonstr/rig/pkg/postrender"
+	"github.com/gonstr/rig/pkg/sign"
+	"github.com/gonstr/rig/pkg/transform"
+	"github.com/gonstr/rig/pkg/version"
 )
 
 var containsNonWhitespace = regexp.MustCompile(`\S+`)
 
-// FromString builds a rig template from a string
-func FromString(str string, valueMap map[string]interface{}, values []string, stringValues []string) (string, error) {
-	vals, err := createValueMap(valueMap, values, stringValues)
+// Options are options for building templates
+type Options struct {
+	// Dir is the dir the rig file, the templates path and ValueFiles are
+	// resolved against. Defaults to the current dir. Relative paths in the rig
+	// file, i.e. local templates, value files, patches and file references,
+	// are always resolved against the dir of the rig file
+	Dir string
+	// ValueMap is merged on top of the values and value files of rig.yaml
+	ValueMap map[string]interface{}
+	// ValueFiles are merged in order on top of the value files of rig.yaml
+	ValueFiles []string
+	// Values are values in --value format, key1=val1,key2=val2
+	Values []string
+	// StringValues are values in --string-value format
+	StringValues []string
+	// Verify requires templates to be signed by a trusted key
+	Verify bool
+	// Offline builds templates from the cache without fetching
+	Offline bool
+	// AllowEnv lists environment variables templates can read in addition to
+	// the variables listed in allowEnv in rig.yaml
+	AllowEnv []string
+	// Logger logs the build steps. Nothing is logged if it is nil
+	Logger *log.Logger
+}
+
+// dir returns the dir of the options or the current dir
+func (opts Options) dir() (string, error) {
+	if opts.Dir != "" {
+		return opts.Dir, nil
+	}
+
+	return os.Getwd()
+}
+
+func (opts Options) logf(format string, args ...interface{}) {
+	if opts.Logger != nil {
+		opts.Logger.Printf(format, args...)
+	}
+}
+
+// FromString builds a rig template from a string. If opts.AllowEnv is not
+// empty, the template can only read the environment variables it lists
+func FromString(ctx context.Context, str string, opts Options) (string, error) {
+	dir, err := opts.dir()
 	if err != nil {
 		return "", err
 	}
 
-	bytes, err := engine.Render(str, vals, true)
+	vals, err := createValueMap(ctx, nil, nil, opts, dir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	bytes, err := engine.RenderWithPartials(str, nil, vals, true, envPolicy(nil, opts.AllowEnv))
 	if err != nil {
 		return "", err
 	}
@@ -30,101 +90,251 @@ func FromString(str string, valueMap map[string]interface{}, values []string, st
 	return strings.TrimSpace(string(bytes)), nil
 }
 
-// FromTemplatesPath builds a template from a path
-func FromTemplatesPath(templatesPath string, valueMap map[string]interface{}, values []string, stringValues []string) (string, error) {
-	wd, err := os.Getwd()
+// FromTemplatesPath builds a template from a path. If opts.AllowEnv is not
+// empty, the templates can only read the environment variables it lists
+func FromTemplatesPath(ctx context.Context, templatesPath string, opts Options) (string, error) {
+	dir, err := opts.dir()
 	if err != nil {
 		return "", err
 	}
 
-	files, err := fs.ReadFiles(path.Join(wd, templatesPath))
+	vals, err := createValueMap(ctx, nil, nil, opts, dir)
 	if err != nil {
 		return "", err
 	}
 
-	var rendered []string
-	for i := 0; i < len(files); i++ {
-		str, err := FromString(files[i], valueMap, values, stringValues)
-		if err != nil {
-			return "", err
-		}
-
-		if containsNonWhitespace.MatchString(str) {
-			rendered = append(rendered, str)
-		}
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
 
-	concatinated := strings.Join(rendered, "\n---\n")
-	trimmed := strings.TrimSpace(concatinated)
-
-	return trimmed, nil
+	return renderFiles(resolve(dir, templatesPath), vals, envPolicy(nil, opts.AllowEnv))
 }
 
-// FromRigFile builds a template from rig.yaml
-func FromRigFile(filePath string, values []string, stringValues []string) (string, error) {
-	ctx, err := context.FromFile(filePath)
+// FromRigFile builds a template from a rig file, rig.yaml. The patches and
+// then the common labels, annotations and namespace of rig.yaml are applied
+// to the rendered objects before the post render steps are run. Remote
+// templates and templates with allowEnv in rig.yaml can only read the
+// environment variables allowed by rig.yaml and opts. The build is stopped
+// if ctx is done
+func FromRigFile(ctx context.Context, filePath string, opts Options) (string, error) {
+	dir, err := opts.dir()
 	if err != nil {
 		return "", err
 	}
 
-	if ctx.Scheme() == "" {
-		return FromTemplatesPath(ctx.Path(), ctx.Values(), values, stringValues)
-	}
+	filePath = resolve(dir, filePath)
 
-	ownerDir, err := ctx.OwnerDir()
+	file, err := fs.UnmarshalYaml(filePath)
 	if err != nil {
 		return "", err
 	}
 
-	repoDir, err := ctx.RepoDir()
+	tmpl, err := rigcontext.FromMap(file, filePath)
 	if err != nil {
 		return "", err
 	}
 
-	gitURL, err := ctx.RepoURL()
+	patches, err := patch.FromMap(file, filePath)
 	if err != nil {
 		return "", err
 	}
 
-	err = git.Sync(ownerDir, repoDir, gitURL)
+	common, err := transform.FromMap(file, filePath)
 	if err != nil {
 		return "", err
 	}
 
-	tmpDir, err := fs.TempDir()
+	steps, err := postrender.FromMap(file, filePath)
 	if err != nil {
 		return "", err
 	}
 
-	defer os.RemoveAll(tmpDir)
+	var templateDir string
+
+	if tmpl.Scheme() == "" {
+		templateDir = resolve(path.Dir(filePath), tmpl.Path())
+	} else {
+		url, _ := tmpl.URL()
+		opts.logf("Fetching template %s", url)
+
+		var cleanup func()
+		templateDir, cleanup, err = fetch.Template(ctx, tmpl, opts.Offline)
+		defer cleanup()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
-	err = git.Checkout(repoDir, tmpDir, ctx.Gitref(), ctx.Path())
+	err = checkTemplate(templateDir)
 	if err != nil {
 		return "", err
 	}
 
-	if ctx.Digest() != "" {
-		newdigest, err := fs.DirectoryDigest(path.Join(tmpDir, ctx.Path(), "templates"))
+	if opts.Verify {
+		opts.logf("Verifying template signature")
+
+		err = sign.Verify(tmpl, templateDir)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	templatesDir := fetch.TemplatesDir(templateDir)
+
+	if tmpl.Digest() != "" {
+		newdigest, ok, err := fs.VerifyDirectoryDigest(templatesDir, tmpl.Digest())
 		if err != nil {
 			return "", err
 		}
-		if ctx.Digest() != newdigest {
+		if !ok {
 			return "", fmt.Errorf("Template digest does not match: %s", newdigest)
 		}
 	}
 
-	files, err := fs.ReadFiles(path.Join(path.Join(tmpDir, ctx.Path(), "templates")))
+	vals, err := createValueMap(ctx, tmpl.Values(), tmpl.ValueFiles(), opts, path.Dir(filePath))
+	if err != nil {
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	opts.logf("Rendering templates in %s", templatesDir)
+
+	output, err := renderFiles(templatesDir, vals, envPolicy(tmpl, opts.AllowEnv))
+	if err != nil {
+		return "", err
+	}
+
+	if len(patches) > 0 {
+		opts.logf("Applying %d patches", len(patches))
+	}
+
+	output, err = patch.Apply(patches, output)
+	if err != nil {
+		return "", err
+	}
+
+	output, err = transform.Apply(common, output)
+	if err != nil {
+		return "", err
+	}
+
+	for _, step := range steps {
+		opts.logf("Running post render step %s", step)
+	}
+
+	return postrender.Run(ctx, steps, output, path.Dir(filePath))
+}
+
+// WatchPaths returns the local files a build from a rig file depends on. That
+// is the rig file itself, the value files and, for local templates, the
+// template dir. Remote templates are not watched
+func WatchPaths(filePath string, opts Options) []string {
+	dir, err := opts.dir()
+	if err != nil {
+		return []string{filePath}
+	}
+
+	filePath = resolve(dir, filePath)
+	paths := []string{filePath}
+
+	tmpl, err := rigcontext.FromFile(filePath)
+	if err != nil {
+		return paths
+	}
+
+	paths = append(paths, tmpl.ValueFiles()...)
+
+	for _, valueFile := range opts.ValueFiles {
+		paths = append(paths, resolve(dir, valueFile))
+	}
+
+	if tmpl.Scheme() != "" {
+		return paths
+	}
+
+	return append(paths, resolve(path.Dir(filePath), tmpl.Path()))
+}
+
+// checkTemplate returns an error if the template in templateDir can not be
+// built by this version of rig
+func checkTemplate(templateDir string) error {
+	meta, err := metadata.FromDir(templateDir)
+	if err != nil {
+		return err
+	}
+
+	if meta != nil {
+		return meta.CheckRigVersion(version.Version)
+	}
+
+	return nil
+}
+
+// envPolicy returns the environment variables templates can read. Templates
+// are sandboxed if they are remote, if rig.yaml declares allowEnv or if
+// variables are allowed explicitly. A nil policy allows all variables
+func envPolicy(tmpl rigcontext.Context, allowEnv []string) *engine.EnvPolicy {
+	if tmpl == nil || (tmpl.Scheme() == "" && tmpl.AllowEnv() == nil) {
+		if len(allowEnv) == 0 {
+			return nil
+		}
+
+		return &engine.EnvPolicy{Allow: allowEnv}
+	}
+
+	return &engine.EnvPolicy{Allow: append(append([]string{}, tmpl.AllowEnv()...), allowEnv...)}
+}
+
+// resolve resolves a path relative to dir. Absolute paths are returned as is
+func resolve(dir string, p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+
+	return path.Join(dir, p)
+}
+
+// renderFiles renders a template file or all template files in a directory.
+// Files prefixed with an underscore and with a .tpl extension, e.g.
+// _helpers.tpl, are partials. Partials are not rendered but the templates they
+// define can be used by all other files
+func renderFiles(dirOrFilePath string, vals map[string]interface{}, policy *engine.EnvPolicy) (string, error) {
+	filePaths, err := fs.ListFiles(dirOrFilePath)
 	if err != nil {
 		return "", err
 	}
 
+	var files []string
+	var partials []string
+	for i := 0; i < len(filePaths); i++ {
+		bytes, err := ioutil.ReadFile(filePaths[i])
+		if err != nil {
+			return "", err
+		}
+
+		if isPartial(filePaths[i]) {
+			partials = append(partials, string(bytes))
+		} else {
+			files = append(files, string(bytes))
+		}
+	}
+
 	var rendered []string
 	for i := 0; i < len(files); i++ {
-		str, err := FromString(files[i], ctx.Values(), values, stringValues)
+		bytes, err := engine.RenderWithPartials(files[i], partials, vals, true, policy)
 		if err != nil {
 			return "", err
 		}
 
+		str := strings.TrimSpace(string(bytes))
+
 		if containsNonWhitespace.MatchString(str) {
 			rendered = append(rendered, str)
 		}
@@ -135,3 +345,9 @@ func FromRigFile(filePath string, values []string, stringValues []string) (strin
 
 	return trimmed, nil
 }
+
+// isPartial returns true if a file is a partial
+func isPartial(filePath string) bool {
+	name := path.Base(filePath)
+	return strings.HasPrefix(name, "_") && path.Ext(name) == ".tpl"
+}
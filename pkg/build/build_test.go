@@ -0,0 +1,185 @@
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// setup sets up a temp home and cache dir and returns a temp dir
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rig-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+	os.Setenv("RIG_CACHE_DIR", path.Join(dir, "cache"))
+
+	return dir
+}
+
+// writeFiles writes files relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		filePath := path.Join(dir, name)
+
+		err := os.MkdirAll(path.Dir(filePath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// gitRepo creates a git repository with files in dir and returns its path
+func gitRepo(t *testing.T, dir string, files map[string]string) string {
+	repoDir := path.Join(dir, "templates.git")
+
+	writeFiles(t, repoDir, files)
+
+	runGit(t, repoDir, "init", "-q")
+	runGit(t, repoDir, "checkout", "-q", "-b", "master")
+	commit(t, repoDir, "templates")
+
+	return repoDir
+}
+
+func commit(t *testing.T, repoDir string, message string) {
+	runGit(t, repoDir, "add", "-A")
+	runGit(t, repoDir, "commit", "-q", "-m", message)
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	args = append([]string{"-c", "user.name=rig", "-c", "user.email=rig@example.com"}, args...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s\n%s", args, err, out)
+	}
+}
+
+func TestFromRigFileLocalRepo(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/service.yaml": "kind: Service\nmetadata:\n  name: {{ .values.name }}\n  port: 80",
+	})
+
+	runGit(t, repoDir, "tag", "v1")
+
+	writeFiles(t, repoDir, map[string]string{
+		"simple-app/templates/service.yaml": "kind: Service\nmetadata:\n  name: {{ .values.name }}\n  port: 8080",
+	})
+	commit(t, repoDir, "port")
+
+	appDir := path.Join(dir, "app")
+
+	for gitref, port := range map[string]string{"v1": "80", "master": "8080"} {
+		writeFiles(t, appDir, map[string]string{
+			"rig.yaml": "template:\n  url: file://" + repoDir + "//simple-app#" + gitref + "\nvalues:\n  name: app\n",
+		})
+
+		out, err := FromRigFile(context.Background(), "rig.yaml", Options{Dir: appDir})
+		if err != nil {
+			t.Fatalf("%s: %s", gitref, err)
+		}
+
+		expected := "kind: Service\nmetadata:\n  name: app\n  port: " + port
+		if out != expected {
+			t.Errorf("%s: expected %s, got %s", gitref, expected, out)
+		}
+	}
+}
+
+func TestPartials(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"templates/_helpers.tpl":  `{{- define "name" -}}{{ .values.name }}{{- end -}}`,
+		"templates/_service.yaml": "kind: Service\nmetadata:\n  name: {{ include \"name\" . }}",
+		"templates/other.tpl":     "kind: ConfigMap",
+	})
+
+	out, err := renderFiles(path.Join(dir, "templates"), map[string]interface{}{"values": map[string]interface{}{"name": "app"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "kind: Service\nmetadata:\n  name: app\n---\nkind: ConfigMap"
+	if out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+}
+
+func TestFromRigFileEnv(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("RIG_TEST_TAG", "v1")
+	defer os.Unsetenv("RIG_TEST_TAG")
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/deployment.yaml": "kind: Deployment\nimage: app:{{ env \"RIG_TEST_TAG\" }}",
+	})
+
+	appDir := path.Join(dir, "app")
+	localDir := path.Join(dir, "local")
+
+	writeFiles(t, localDir, map[string]string{
+		"templates/deployment.yaml": "kind: Deployment\nimage: app:{{ env \"RIG_TEST_TAG\" }}",
+	})
+
+	remote := "template:\n  url: file://" + repoDir + "//simple-app#master\n"
+	local := "template:\n  path: " + localDir + "\n"
+
+	for _, test := range []struct {
+		name     string
+		rigFile  string
+		allowEnv []string
+		denied   bool
+	}{
+		{"remote", remote, nil, true},
+		{"remote with allowEnv", remote + "  allowEnv:\n    - RIG_TEST_*\n", nil, false},
+		{"remote with --allow-env", remote, []string{"RIG_TEST_TAG"}, false},
+		{"remote with other --allow-env", remote, []string{"OTHER"}, true},
+		{"local", local, nil, false},
+		{"local with empty allowEnv", local + "  allowEnv: []\n", nil, true},
+	} {
+		writeFiles(t, appDir, map[string]string{"rig.yaml": test.rigFile})
+
+		out, err := FromRigFile(context.Background(), "rig.yaml", Options{Dir: appDir, AllowEnv: test.allowEnv})
+
+		if test.denied {
+			if err == nil || !strings.Contains(err.Error(), "not allowed to read environment variable 'RIG_TEST_TAG'") {
+				t.Errorf("%s: expected reading RIG_TEST_TAG to be denied, got %v", test.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if out != "kind: Deployment\nimage: app:v1" {
+			t.Errorf("%s: unexpected output %s", test.name, out)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package build
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/ghodss/yaml"
 	"k8s.io/helm/pkg/strvals"
+
+	"github.com/gonstr/rig/pkg/secrets"
+	"github.com/gonstr/rig/pkg/valueref"
 )
 
-// createValueMap merges a value map with value arrays. Values defined in the
-// value arrays supercede values in the value map
-func createValueMap(valueMap map[string]interface{}, values []string, stringValues []string) (map[string]interface{}, error) {
+// createValueMap merges a value map with value files and the values of opts.
+// Value files, first the given files and then the files of opts, are merged in
+// order and supercede values in the value map. The value map of opts and then
+// the value arrays of opts supercede both. Encrypted value files are decrypted
+// in memory. Value references are resolved last, relative to dir
+func createValueMap(ctx context.Context, valueMap map[string]interface{}, valueFiles []string, opts Options, dir string) (map[string]interface{}, error) {
 	vals := make(map[string]interface{})
 
 	if valueMap != nil {
@@ -17,21 +25,78 @@ func createValueMap(valueMap map[string]interface{}, values []string, stringValu
 		}
 	}
 
+	optsDir, err := opts.dir()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, valueFile := range opts.ValueFiles {
+		valueFiles = append(valueFiles, resolve(optsDir, valueFile))
+	}
+
+	for _, valueFile := range valueFiles {
+		bytes, err := secrets.ReadFile(valueFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var fileVals map[string]interface{}
+
+		err = yaml.Unmarshal(bytes, &fileVals)
+		if err != nil {
+			return nil, fmt.Errorf("%s is malformed: %s", valueFile, err)
+		}
+
+		vals = mergeValues(vals, fileVals)
+	}
+
+	if opts.ValueMap != nil {
+		vals = mergeValues(vals, opts.ValueMap)
+	}
+
 	// User specified a value via --value
-	for _, value := range values {
+	for _, value := range opts.Values {
 		if err := strvals.ParseInto(value, vals); err != nil {
 			return nil, fmt.Errorf("failed parsing --value data: %s", err)
 		}
 	}
 
 	// User specified a value via --string-value
-	for _, value := range stringValues {
+	for _, value := range opts.StringValues {
 		if err := strvals.ParseIntoString(value, vals); err != nil {
 			return nil, fmt.Errorf("failed parsing --string-value data: %s", err)
 		}
 	}
 
+	vals, err = valueref.Resolve(ctx, vals, dir)
+	if err != nil {
+		return nil, err
+	}
+
 	return map[string]interface{}{
 		"values": vals,
 	}, nil
 }
+
+// mergeValues merges src into dst. Maps are merged recursively, all other
+// values in src replace the values in dst. Maps of src are copied
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		srcMap, srcOk := v.(map[string]interface{})
+		dstMap, dstOk := dst[k].(map[string]interface{})
+
+		if srcOk {
+			merged := make(map[string]interface{}, len(dstMap))
+			if dstOk {
+				for dk, dv := range dstMap {
+					merged[dk] = dv
+				}
+			}
+			dst[k] = mergeValues(merged, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gonstr/rig/pkg/config"
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+// lastUsedFile is touched in the git dir of a cached repo whenever it is used
+const lastUsedFile = "rig-last-used"
+
+// Repo is a cached template repository or template archive
+type Repo struct {
+	Dir      string
+	Size     int64
+	LastUsed time.Time
+}
+
+// Touch marks a cached repo or archive as used
+func Touch(repoDir string) error {
+	if isArchive(repoDir) {
+		now := time.Now()
+		return os.Chtimes(repoDir, now, now)
+	}
+
+	return ioutil.WriteFile(path.Join(gitDir(repoDir), lastUsedFile), nil, 0644)
+}
+
+// List returns all cached repos and archives sorted by dir. Only repos marked
+// as used by rig and archives in the <host>/<owner>/<archive> layout of the
+// cache are listed. Other repos in the cache dir are ignored
+func List() ([]Repo, error) {
+	cacheDir, err := config.CacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if !fs.PathExists(cacheDir) {
+		return nil, nil
+	}
+
+	var repos []Repo
+
+	err = filepath.Walk(cacheDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && !isRepo(filePath) || !info.IsDir() && !isCachedArchive(cacheDir, filePath) {
+			return nil
+		}
+
+		if !info.IsDir() || isCachedRepo(filePath) {
+			repo, err := stat(filePath)
+			if err != nil {
+				return err
+			}
+
+			repos = append(repos, repo)
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Dir < repos[j].Dir
+	})
+
+	return repos, nil
+}
+
+// Prune removes cached repos that have not been used since maxAge ago. The
+// removed repos are returned. Cache dirs that are likely to contain files
+// not written by rig, the home dir or a dir with a config.yaml other than the
+// rig config dir, are refused
+func Prune(maxAge time.Duration) ([]Repo, error) {
+	err := checkCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []Repo
+	for _, repo := range repos {
+		if time.Since(repo.LastUsed) < maxAge {
+			continue
+		}
+
+		// Repos in use by other rig processes are skipped
+		unlock, err := Lock(context.Background(), repo.Dir, 0)
+		if err != nil {
+			continue
+		}
+
+		err = os.RemoveAll(repo.Dir)
+		if err != nil {
+			unlock()
+			return pruned, err
+		}
+
+		// The lock file is removed while it is held so no other process is
+		// using it. Windows can not remove open files so it is removed after
+		// unlocking there, which fails if another process has it open
+		lockPath := repo.Dir + ".lock"
+		removed := os.Remove(lockPath) == nil
+		unlock()
+		if !removed {
+			os.Remove(lockPath)
+		}
+
+		err = removeEmptyParents(repo.Dir)
+		if err != nil {
+			return pruned, err
+		}
+
+		pruned = append(pruned, repo)
+	}
+
+	return pruned, nil
+}
+
+// Clean removes all cached repos. Other files in the cache dir, such as the
+// user config, are left untouched. The removed repos are returned
+func Clean() ([]Repo, error) {
+	return Prune(0)
+}
+
+// checkCacheDir returns an error if the cache dir is the home dir or one of
+// its parents, or if it contains a config.yaml and is not the rig config dir
+func checkCacheDir() error {
+	cacheDir, err := config.CacheDir()
+	if err != nil {
+		return err
+	}
+
+	home, err := fs.HomeDir()
+	if err != nil {
+		return err
+	}
+
+	if cacheDir == home || strings.HasPrefix(home, strings.TrimSuffix(cacheDir, "/")+"/") {
+		return fmt.Errorf("Refusing to remove repositories from %s since it contains the home dir. Set RIG_CACHE_DIR or cacheDir in ~/.rig/config.yaml to a dir used only by rig", cacheDir)
+	}
+
+	configDir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	if cacheDir != configDir && fs.PathExists(path.Join(cacheDir, "config.yaml")) {
+		return fmt.Errorf("Refusing to remove repositories from %s since it contains a config.yaml. Set RIG_CACHE_DIR or cacheDir in ~/.rig/config.yaml to a dir used only by rig", cacheDir)
+	}
+
+	return nil
+}
+
+// removeEmptyParents removes the parent dirs of a removed repo that are left
+// empty
+func removeEmptyParents(repoDir string) error {
+	cacheDir, err := config.CacheDir()
+	if err != nil {
+		return err
+	}
+
+	for dir := filepath.Dir(repoDir); dir != cacheDir && len(dir) > len(cacheDir); dir = filepath.Dir(dir) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil || len(files) > 0 {
+			break
+		}
+
+		err = os.Remove(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stat returns the size and last used time of a cached repo
+func stat(repoDir string) (Repo, error) {
+	repo := Repo{Dir: repoDir}
+
+	err := filepath.Walk(repoDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			repo.Size += info.Size()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return repo, err
+	}
+
+	info, err := os.Stat(path.Join(gitDir(repoDir), lastUsedFile))
+	if err != nil {
+		info, err = os.Stat(repoDir)
+		if err != nil {
+			return repo, err
+		}
+	}
+
+	repo.LastUsed = info.ModTime()
+
+	return repo, nil
+}
+
+// gitDir returns the git dir of a repo. Repos can either be regular or bare
+func gitDir(repoDir string) string {
+	dotGit := path.Join(repoDir, ".git")
+
+	if fs.PathExists(dotGit) {
+		return dotGit
+	}
+
+	return repoDir
+}
+
+// isArchive returns true if filePath is a template archive
+func isArchive(filePath string) bool {
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	return strings.HasSuffix(filePath, ".tgz") || strings.HasSuffix(filePath, ".tar.gz")
+}
+
+// isCachedRepo returns true if a repo was cached by rig. Cached repos are
+// marked as used whenever they are fetched
+func isCachedRepo(repoDir string) bool {
+	return fs.PathExists(path.Join(gitDir(repoDir), lastUsedFile))
+}
+
+// isCachedArchive returns true if filePath is an archive downloaded by rig,
+// i.e. an archive at <host>/<owner>/<archive> or deeper in the cache dir
+func isCachedArchive(cacheDir string, filePath string) bool {
+	if !isArchive(filePath) {
+		return false
+	}
+
+	rel, err := filepath.Rel(cacheDir, filePath)
+	if err != nil {
+		return false
+	}
+
+	return len(strings.Split(filepath.ToSlash(rel), "/")) >= 3
+}
+
+// isRepo returns true if dir is a regular or bare git repo or an oci layout
+func isRepo(dir string) bool {
+	if fs.PathExists(path.Join(dir, ".git")) || fs.PathExists(path.Join(dir, "oci-layout")) {
+		return true
+	}
+
+	return fs.PathExists(path.Join(dir, "HEAD")) && fs.PathExists(path.Join(dir, "objects"))
+}
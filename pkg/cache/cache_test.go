@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rig-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+	os.Setenv("RIG_CACHE_DIR", path.Join(dir, "cache"))
+
+	return dir
+}
+
+func mkdir(t *testing.T, dirs ...string) {
+	for _, dir := range dirs {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPruneCachedOnly(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	cached := path.Join(dir, "cache", "github.com", "owner", "cached")
+	other := path.Join(dir, "cache", "github.com", "owner", "other")
+	mkdir(t, path.Join(cached, ".git"), path.Join(other, ".git"))
+
+	err := Touch(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := Prune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pruned) != 1 || pruned[0].Dir != cached {
+		t.Fatalf("expected only %s to be pruned, got %v", cached, pruned)
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("expected repo not cached by rig to be kept: %s", err)
+	}
+}
+
+func TestPruneRemovesLockFiles(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	repo := path.Join(dir, "cache", "github.com", "owner", "repo")
+	mkdir(t, path.Join(repo, ".git"))
+
+	unlock, err := Lock(context.Background(), repo, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	err = Touch(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(repo + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expected the lock file to be removed: %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "cache", "github.com")); !os.IsNotExist(err) {
+		t.Errorf("expected empty parent dirs to be removed: %v", err)
+	}
+
+	// The repo can be locked again after the lock file is removed
+	unlock, err = Lock(context.Background(), repo, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestPruneRefusesHomeDir(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("RIG_CACHE_DIR", path.Join(dir, "home"))
+
+	repo := path.Join(dir, "home", "src", "github.com", "owner", "repo")
+	mkdir(t, path.Join(repo, ".git"))
+
+	err := Touch(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Prune(time.Duration(0))
+	if err == nil {
+		t.Fatal("expected pruning the home dir to be refused")
+	}
+
+	if _, err := os.Stat(repo); err != nil {
+		t.Errorf("expected repo to be kept: %s", err)
+	}
+}
+
+func TestPruneRefusesDirWithConfig(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	mkdir(t, path.Join(dir, "cache"))
+
+	err := ioutil.WriteFile(path.Join(dir, "cache", "config.yaml"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Clean()
+	if err == nil {
+		t.Fatal("expected cleaning a dir with a config.yaml to be refused")
+	}
+}
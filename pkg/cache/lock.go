@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+const (
+	// LockTimeout is how long to wait for a repo lock held by another process
+	LockTimeout = 5 * time.Minute
+
+	pollInterval = 100 * time.Millisecond
+)
+
+// Lock takes an exclusive lock on a cached repo so concurrent rig processes do
+// not modify the same repo at the same time. The lock is an OS file lock on a
+// lock file next to the repo dir so repos can be locked before they are
+// cloned. Lock files are only removed by Prune and the OS releases the locks
+// of crashed processes. Waiting for the lock is stopped if ctx is done. The
+// returned unlock func must be called to release the lock
+func Lock(ctx context.Context, repoDir string, timeout time.Duration) (func(), error) {
+	lockPath := repoDir + ".lock"
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		file, err := openLock(lockPath)
+		if os.IsNotExist(err) {
+			// The parent dir was removed by Prune after it was created
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Unable to lock %s: %s", lockPath, err)
+		}
+
+		// The lock file may have been removed by Prune after it was opened. A
+		// lock on a removed file does not exclude anyone so it is taken again
+		if locked && !isLockFile(file, lockPath) {
+			unlock(file)
+			file.Close()
+			continue
+		}
+
+		if locked {
+			file.Truncate(0)
+			file.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), hostname())), 0)
+
+			return func() {
+				file.Truncate(0)
+				unlock(file)
+				file.Close()
+			}, nil
+		}
+
+		file.Close()
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for lock %s held by %s", lockPath, owner(lockPath))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// openLock opens or creates a lock file and its parent dirs
+func openLock(lockPath string) (*os.File, error) {
+	err := fs.EnsureDir(filepath.Dir(lockPath))
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+}
+
+// isLockFile returns true if an open file still is the lock file at lockPath
+func isLockFile(file *os.File, lockPath string) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	current, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(info, current)
+}
+
+func readLock(lockPath string) (int, string) {
+	bytes, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return 0, ""
+	}
+
+	lines := strings.Split(string(bytes), "\n")
+	if len(lines) < 2 {
+		return 0, ""
+	}
+
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil {
+		return 0, ""
+	}
+
+	return pid, lines[1]
+}
+
+func owner(lockPath string) string {
+	pid, host := readLock(lockPath)
+	if pid == 0 {
+		return "an unknown process"
+	}
+
+	return fmt.Sprintf("pid %d on %s", pid, host)
+}
+
+func hostname() string {
+	host, _ := os.Hostname()
+	return host
+}
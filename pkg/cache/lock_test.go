@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockExclusive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoDir := path.Join(dir, "host", "owner", "repo")
+
+	var held, overlaps int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			unlock, err := Lock(context.Background(), repoDir, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if atomic.AddInt32(&held, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+
+			time.Sleep(20 * time.Millisecond)
+
+			atomic.AddInt32(&held, -1)
+			unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if overlaps > 0 {
+		t.Errorf("lock was held by more than one holder %d times", overlaps)
+	}
+}
+
+func TestLockLeftBehind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoDir := path.Join(dir, "repo")
+
+	// A lock file left by a crashed process is not locked
+	err = ioutil.WriteFile(repoDir+".lock", []byte("1\nhost\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := Lock(context.Background(), repoDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Lock(context.Background(), repoDir, 0)
+	if err == nil {
+		t.Fatal("expected a held lock to time out")
+	}
+
+	unlock()
+
+	unlock, err = Lock(context.Background(), repoDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestLockCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoDir := path.Join(dir, "repo")
+
+	unlock, err := Lock(context.Background(), repoDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err = Lock(ctx, repoDir, time.Minute)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected waiting for the lock to stop when ctx is done, got %v", err)
+	}
+}
+
+func TestLockFileRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lockPath := path.Join(dir, "repo.lock")
+
+	file, err := openLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if !isLockFile(file, lockPath) {
+		t.Fatal("expected the opened file to be the lock file")
+	}
+
+	// A file opened before Prune removed it no longer is the lock file
+	err = os.Remove(lockPath)
+	if err != nil {
+		t.Skip("open files can not be removed on this platform")
+	}
+
+	if isLockFile(file, lockPath) {
+		t.Error("expected a removed file to not be the lock file")
+	}
+
+	unlock, err := Lock(context.Background(), path.Join(dir, "repo"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	if isLockFile(file, lockPath) {
+		t.Error("expected a recreated lock file to not be the removed file")
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+// Config is the user config stored in ~/.rig/config.yaml
+type Config struct {
+	// TrustedKeys are public keys in authorized_keys format that are trusted to
+	// sign templates
+	TrustedKeys []string `json:"trustedKeys,omitempty"`
+
+	// CacheDir is where template repositories are cached. Defaults to ~/.rig
+	CacheDir string `json:"cacheDir,omitempty"`
+
+	// Offline makes rig use cached templates without fetching by default
+	Offline bool `json:"offline,omitempty"`
+
+	// FullFetch makes rig fetch the full history of template repositories
+	// instead of only the requested ref
+	FullFetch bool `json:"fullFetch,omitempty"`
+}
+
+// Dir returns the rig config dir
+func Dir() (string, error) {
+	homedir, err := fs.HomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(homedir, ".rig"), nil
+}
+
+// CacheDir returns the template cache dir. The cache dir can be set with the
+// RIG_CACHE_DIR environment variable or cacheDir in the user config
+func CacheDir() (string, error) {
+	if dir := os.Getenv("RIG_CACHE_DIR"); dir != "" {
+		return filepath.Abs(dir)
+	}
+
+	c, err := Load()
+	if err != nil {
+		return "", err
+	}
+
+	if c.CacheDir != "" {
+		dir, err := homedir.Expand(c.CacheDir)
+		if err != nil {
+			return "", err
+		}
+
+		return filepath.Abs(dir)
+	}
+
+	return Dir()
+}
+
+// Load loads the user config. An empty config is returned if there is no
+// config file
+func Load() (*Config, error) {
+	var c Config
+
+	err := load("config.yaml", &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// HostCredentials are credentials for a git host
+type HostCredentials struct {
+	// Username is used together with the token. Defaults to x-access-token
+	Username string `json:"username,omitempty"`
+	// Token is an access token for https urls
+	Token string `json:"token,omitempty"`
+	// TokenEnv is the name of an environment variable holding an access token
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// SSHKey is the path to a private key for ssh urls
+	SSHKey string `json:"sshKey,omitempty"`
+}
+
+// Credentials are git host credentials stored in ~/.rig/credentials.yaml
+type Credentials struct {
+	Hosts map[string]HostCredentials `json:"hosts,omitempty"`
+}
+
+// LoadCredentials loads the user credentials. Empty credentials are returned if
+// there is no credentials file
+func LoadCredentials() (*Credentials, error) {
+	var c Credentials
+
+	err := load("credentials.yaml", &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Repository is a registered template index
+type Repository struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Repositories are the template indexes stored in ~/.rig/repositories.yaml
+type Repositories struct {
+	Repositories []Repository `json:"repositories"`
+}
+
+// LoadRepositories loads the registered template indexes
+func LoadRepositories() (*Repositories, error) {
+	var r Repositories
+
+	err := load("repositories.yaml", &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// SaveRepositories saves the registered template indexes
+func SaveRepositories(r *Repositories) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+
+	err = fs.EnsureDir(dir)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dir, "repositories.yaml"), bytes, 0644)
+}
+
+// load unmarshals a yaml file in the config dir. Missing files are ignored
+func load(fileName string, v interface{}) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+
+	filePath := path.Join(dir, fileName)
+
+	if !fs.PathExists(filePath) {
+		return nil
+	}
+
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.Unmarshal(bytes, v)
+	if err != nil {
+		return fmt.Errorf("%s is malformed: %s", filePath, err)
+	}
+
+	return nil
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/gonstr/rig/pkg/config"
 	"github.com/gonstr/rig/pkg/fs"
 )
 
@@ -19,31 +22,89 @@ type Context interface {
 	Path() string
 	Gitref() string
 	Digest() string
+	Archive() bool
+	ManifestDigest() string
+	TrustedKeys() []string
+	AllowEnv() []string
 	RepoURL() (string, error)
 	URL() (string, error)
 	Values() map[string]interface{}
+	ValueFiles() []string
 	OwnerDir() (string, error)
 	RepoDir() (string, error)
 }
 
 type context struct {
-	scheme string
-	host   string
-	owner  string
-	repo   string
-	path   string
-	gitref string
-	digest string
-	values map[string]interface{}
+	scheme   string
+	user     string
+	host     string
+	owner    string
+	repo     string
+	path     string
+	gitref   string
+	digest   string
+	archive  bool
+	manifest string
+	keys     []string
+	allowEnv []string
+	values   map[string]interface{}
+	files    []string
 }
 
-// FromURL returns a new Context from an url string
+// scpURL matches scp-like ssh urls such as git@github.com:owner/repo
+var scpURL = regexp.MustCompile(`^(?:([\w.-]+)@)?([\w.-]+):([^/].*)$`)
+
+// localRepoURL matches paths to local git repositories such as
+// ../templates.git//simple-app. Paths must start with ./, ../ or / so urls
+// without a scheme, e.g. github.com/org/repo//app, are not taken for paths
+var localRepoURL = regexp.MustCompile(`^\.{0,2}/`)
+
+// archiveURL matches urls and paths to packaged template archives
+var archiveURL = regexp.MustCompile(`\.(tgz|tar\.gz)$`)
+
+// ociReference matches the repository, tag and manifest digest of an oci url
+// path such as /templates/simple-app:1.2.0@sha256:<hex>
+var ociReference = regexp.MustCompile(`^/([a-z0-9._/-]+?)(?::([\w][\w.-]*))?(?:@(sha256:[a-f0-9]{64}))?$`)
+
+// FromURL returns a new Context from an url string. Regular urls, scp-like
+// ssh urls, file urls, paths to local git repositories, urls or paths to
+// template archives and oci urls are supported
 func FromURL(urlString string) (Context, error) {
+	if !strings.Contains(urlString, "://") {
+		if m := scpURL.FindStringSubmatch(urlString); m != nil {
+			user := m[1]
+			if user != "" {
+				user += "@"
+			}
+			urlString = fmt.Sprintf("ssh://%s%s/%s", user, m[2], m[3])
+		}
+	}
+
 	u, err := url.Parse(urlString)
 	if err != nil {
 		return nil, err
 	}
 
+	var user string
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword || u.Scheme != "ssh" {
+			return nil, fmt.Errorf("Template urls can not contain credentials. Configure credentials for %s in ~/.rig/credentials.yaml instead", u.Hostname())
+		}
+		user = u.User.Username()
+	}
+
+	if u.Scheme == "oci" {
+		return fromOCI(u, urlString)
+	}
+
+	if archiveURL.MatchString(u.Path) {
+		return fromArchive(u, urlString)
+	}
+
+	if u.Scheme == "file" || (u.Scheme == "" && localRepoURL.MatchString(urlString)) {
+		return fromLocalRepo(u, urlString)
+	}
+
 	if u.Scheme == "" {
 		return nil, fmt.Errorf("Unable to parse url scheme: %s", urlString)
 	}
@@ -61,17 +122,167 @@ func FromURL(urlString string) (Context, error) {
 		gitref = "master"
 	}
 
-	splitPath := strings.Split(u.Path, "/")
+	owner, repo, path, err := splitPath(u.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return context{scheme: u.Scheme, user: user, host: u.Host, owner: owner, repo: repo, path: path, gitref: gitref, digest: "", values: nil}, nil
+}
+
+// fromLocalRepo returns a new Context for a git repository on the local file
+// system. The repo and template path are separated by a double slash, e.g.
+// file:///srv/templates.git//simple-app or ../templates.git//simple-app
+func fromLocalRepo(u *url.URL, urlString string) (Context, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("File urls can not have a host: %s", urlString)
+	}
+
+	repoPath := u.Path
+	templatePath := ""
+
+	if i := strings.Index(u.Path, "//"); i != -1 {
+		repoPath = u.Path[:i]
+		templatePath = strings.Trim(u.Path[i+2:], "/")
+	}
+
+	repoPath = path.Clean(repoPath)
+
+	if u.Scheme == "file" && !path.IsAbs(repoPath) {
+		return nil, fmt.Errorf("File urls must be absolute: %s", urlString)
+	}
+
+	for _, segment := range strings.Split(templatePath, "/") {
+		if segment == "." || segment == ".." {
+			return nil, fmt.Errorf("Invalid template path: %s", templatePath)
+		}
+	}
+
+	gitref := u.Fragment
+	if gitref == "" {
+		gitref = "master"
+	}
+
+	return context{scheme: "file", user: "", host: "", owner: path.Dir(repoPath), repo: path.Base(repoPath), path: templatePath, gitref: gitref, digest: "", values: nil}, nil
+}
+
+// fromArchive returns a new Context for a template archive. Archives are either
+// downloaded over http or read from the local file system
+func fromArchive(u *url.URL, urlString string) (Context, error) {
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("Template archive urls can not have a gitref: %s", urlString)
+	}
+
+	archivePath := path.Clean(u.Path)
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("Unable to parse url host: %s", urlString)
+		}
+
+		return context{scheme: u.Scheme, host: u.Host, owner: strings.Trim(path.Dir(archivePath), "/"), repo: path.Base(archivePath), archive: true}, nil
+	case "file", "":
+		if u.Host != "" {
+			return nil, fmt.Errorf("File urls can not have a host: %s", urlString)
+		}
+
+		if u.Scheme == "file" && !path.IsAbs(archivePath) {
+			return nil, fmt.Errorf("File urls must be absolute: %s", urlString)
+		}
+
+		return context{scheme: "file", owner: path.Dir(archivePath), repo: path.Base(archivePath), archive: true}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported template archive url scheme: %s", urlString)
+}
+
+// fromOCI returns a new Context for a template archive in an oci registry,
+// e.g. oci://registry.local/templates/simple-app:1.2.0. The tag defaults to
+// latest. A manifest digest can be appended to pin the tag to a manifest
+func fromOCI(u *url.URL, urlString string) (Context, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("Unable to parse url host: %s", urlString)
+	}
+
+	if u.Fragment != "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("Invalid oci url: %s", urlString)
+	}
+
+	m := ociReference.FindStringSubmatch(u.Path)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid oci url: %s", urlString)
+	}
+
+	for _, segment := range strings.Split(m[1], "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("Invalid oci url: %s", urlString)
+		}
+	}
 
-	if len(splitPath) < 3 {
-		return nil, fmt.Errorf("Invalid git repository url: %s", u.Path)
+	tag := m[2]
+	if tag == "" {
+		tag = "latest"
 	}
 
-	owner := splitPath[1]
-	repo := splitPath[2]
-	path := strings.Join(splitPath[3:], "/")
+	owner := ""
+	repo := m[1]
+	if i := strings.LastIndex(repo, "/"); i != -1 {
+		owner = repo[:i]
+		repo = repo[i+1:]
+	}
 
-	return context{scheme: u.Scheme, host: u.Host, owner: owner, repo: repo, path: path, gitref: gitref, digest: "", values: nil}, nil
+	return context{scheme: "oci", host: u.Host, owner: owner, repo: repo, gitref: tag, archive: true, manifest: m[3]}, nil
+}
+
+// splitPath splits an url path into owner, repo and template path. The repo
+// and template path can be separated by a double slash which allows owners of
+// any depth, e.g. /group/subgroup/repo.git//templates/app. Without a double
+// slash the path is expected to be /owner/repo/template/path
+func splitPath(urlPath string) (string, string, string, error) {
+	var repoPath, templatePath string
+
+	if i := strings.Index(urlPath, "//"); i != -1 {
+		repoPath = strings.Trim(urlPath[:i], "/")
+		templatePath = strings.Trim(urlPath[i+2:], "/")
+	} else {
+		splitPath := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+		if len(splitPath) < 2 {
+			return "", "", "", fmt.Errorf("Invalid git repository url: %s", urlPath)
+		}
+
+		repoPath = strings.Join(splitPath[:2], "/")
+		templatePath = strings.Join(splitPath[2:], "/")
+	}
+
+	for _, segment := range strings.Split(repoPath+"/"+templatePath, "/") {
+		if segment == "." || segment == ".." {
+			return "", "", "", fmt.Errorf("Invalid git repository url: %s", urlPath)
+		}
+	}
+
+	if repoPath == "" {
+		return "", "", "", fmt.Errorf("Invalid git repository url: %s", urlPath)
+	}
+
+	owner := ""
+	repo := repoPath
+	if i := strings.LastIndex(repoPath, "/"); i != -1 {
+		owner = repoPath[:i]
+		repo = repoPath[i+1:]
+	}
+
+	return owner, repo, templatePath, nil
+}
+
+// FromPath returns a new Context from a local template path
+func FromPath(templatePath string) (Context, error) {
+	if templatePath == "" {
+		return nil, errors.New("Template path can not be empty")
+	}
+
+	return context{scheme: "", host: "", owner: "", repo: "", path: templatePath, gitref: "", digest: "", values: nil}, nil
 }
 
 // FromFile returns a new context from a rig file
@@ -81,6 +292,12 @@ func FromFile(filePath string) (Context, error) {
 		return nil, err
 	}
 
+	return FromMap(file, filePath)
+}
+
+// FromMap returns a new context from the parsed contents of a rig file. Value
+// files are resolved relative to the rig file
+func FromMap(file map[string]interface{}, filePath string) (Context, error) {
 	template, ok := file["template"].(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("%s is malformed: could not parse template", filePath)
@@ -96,21 +313,79 @@ func FromFile(filePath string) (Context, error) {
 
 	templateDigest, _ := template["digest"].(string)
 
+	var templateKeys []string
+	if keys, ok := template["trustedKeys"].([]interface{}); ok {
+		for _, key := range keys {
+			str, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s is malformed: trustedKeys must be a list of strings", filePath)
+			}
+			templateKeys = append(templateKeys, str)
+		}
+	}
+
+	var templateAllowEnv []string
+	if allowEnv, ok := template["allowEnv"]; ok {
+		list, ok := allowEnv.([]interface{})
+		if !ok && allowEnv != nil {
+			return nil, fmt.Errorf("%s is malformed: allowEnv must be a list of strings", filePath)
+		}
+
+		templateAllowEnv = []string{}
+		for _, name := range list {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s is malformed: allowEnv must be a list of strings", filePath)
+			}
+			templateAllowEnv = append(templateAllowEnv, str)
+		}
+	}
+
 	templateValues, templateValuesOk := file["values"].(map[string]interface{})
 	if !templateValuesOk {
 		templateValues = make(map[string]interface{})
 	}
 
+	var valueFiles []string
+	if files, ok := file["valueFiles"].([]interface{}); ok {
+		for _, f := range files {
+			str, ok := f.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s is malformed: valueFiles must be a list of strings", filePath)
+			}
+			if !path.IsAbs(str) {
+				str = path.Join(path.Dir(filePath), str)
+			}
+			valueFiles = append(valueFiles, str)
+		}
+	} else if _, ok := file["valueFiles"]; ok && file["valueFiles"] != nil {
+		return nil, fmt.Errorf("%s is malformed: valueFiles must be a list of strings", filePath)
+	}
+
 	if templateURLOk {
 		ctx, err := FromURL(templateURL)
 		if err != nil {
 			return nil, err
 		}
 
-		return context{scheme: ctx.Scheme(), host: ctx.Host(), owner: ctx.Owner(), repo: ctx.Repo(), path: ctx.Path(), gitref: ctx.Gitref(), digest: templateDigest, values: templateValues}, nil
+		c := ctx.(context)
+
+		// rig install writes the ref of the template url to gitref. It
+		// overrides the ref of the url. Archives have no ref
+		if templateGitrefOk && templateGitref != "" && !c.archive {
+			c.gitref = templateGitref
+		}
+
+		c.digest = templateDigest
+		c.keys = templateKeys
+		c.allowEnv = templateAllowEnv
+		c.values = templateValues
+		c.files = valueFiles
+
+		return c, nil
 	}
 
-	return context{scheme: "", host: "", owner: "", repo: "", path: templatePath, gitref: "", digest: "", values: templateValues}, nil
+	return context{scheme: "", host: "", owner: "", repo: "", path: templatePath, gitref: "", digest: templateDigest, keys: templateKeys, allowEnv: templateAllowEnv, values: templateValues, files: valueFiles}, nil
 }
 
 func (c context) Scheme() string {
@@ -141,12 +416,65 @@ func (c context) Digest() string {
 	return c.digest
 }
 
+func (c context) Archive() bool {
+	return c.archive
+}
+
+func (c context) ManifestDigest() string {
+	return c.manifest
+}
+
+func (c context) TrustedKeys() []string {
+	return c.keys
+}
+
+// AllowEnv returns the environment variables templates can read. It is nil if
+// rig.yaml does not declare allowEnv
+func (c context) AllowEnv() []string {
+	return c.allowEnv
+}
+
 func (c context) URL() (string, error) {
-	if c.scheme == "" {
-		return "", errors.New("Context contains no URL")
+	if c.scheme == "file" {
+		repoPath := path.Join(c.owner, c.repo)
+
+		if path.IsAbs(repoPath) {
+			repoPath = "file://" + repoPath
+		} else if !strings.HasPrefix(repoPath, ".") {
+			repoPath = "./" + repoPath
+		}
+
+		if c.archive {
+			return repoPath, nil
+		}
+
+		return fmt.Sprintf("%s//%s", repoPath, c.path), nil
+	}
+
+	url, err := c.RepoURL()
+	if err != nil {
+		return "", err
 	}
 
-	url := fmt.Sprintf("%s://%s/%s/%s", c.scheme, c.host, c.owner, c.repo)
+	if c.scheme == "oci" {
+		url = fmt.Sprintf("%s:%s", url, c.gitref)
+
+		if c.manifest != "" {
+			url = fmt.Sprintf("%s@%s", url, c.manifest)
+		}
+
+		return url, nil
+	}
+
+	if c.archive {
+		return url, nil
+	}
+
+	// A double slash is only needed when the owner/repo/path form would be
+	// ambiguous
+	if c.owner == "" || strings.Contains(c.owner, "/") {
+		return fmt.Sprintf("%s//%s", url, c.path), nil
+	}
 
 	if c.path != "" {
 		url = fmt.Sprintf("%s/%s", url, c.path)
@@ -160,7 +488,21 @@ func (c context) RepoURL() (string, error) {
 		return "", errors.New("Context contains no URL")
 	}
 
-	url := fmt.Sprintf("%s://%s/%s/%s", c.scheme, c.host, c.owner, c.repo)
+	if c.scheme == "file" {
+		repoPath, err := filepath.Abs(path.Join(c.owner, c.repo))
+		if err != nil {
+			return "", err
+		}
+
+		return "file://" + filepath.ToSlash(repoPath), nil
+	}
+
+	user := c.user
+	if user != "" {
+		user += "@"
+	}
+
+	url := fmt.Sprintf("%s://%s%s/%s", c.scheme, user, c.host, path.Join(c.owner, c.repo))
 
 	return url, nil
 }
@@ -172,17 +514,34 @@ func (c context) Values() map[string]interface{} {
 	return c.values
 }
 
+func (c context) ValueFiles() []string {
+	return c.files
+}
+
 func (c context) OwnerDir() (string, error) {
 	if c.scheme == "" {
 		return "", errors.New("Can not resolve owner dir since context has no URL")
 	}
 
-	homedir, err := fs.HomeDir()
+	cacheDir, err := config.CacheDir()
 	if err != nil {
 		return "", err
 	}
 
-	return path.Join(path.Join(homedir, ".rig"), c.host, c.owner), nil
+	if c.scheme == "file" {
+		owner, err := filepath.Abs(c.owner)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(cacheDir, "file", filepath.ToSlash(owner)), nil
+	}
+
+	if c.scheme == "oci" {
+		return path.Join(cacheDir, "oci", c.host, c.owner), nil
+	}
+
+	return path.Join(cacheDir, c.host, c.owner), nil
 }
 
 func (c context) RepoDir() (string, error) {
@@ -195,5 +554,9 @@ func (c context) RepoDir() (string, error) {
 		return "", err
 	}
 
-	return path.Join(ownerDir, c.repo), nil
+	if c.archive {
+		return path.Join(ownerDir, c.repo), nil
+	}
+
+	return path.Join(ownerDir, strings.TrimSuffix(c.repo, ".git")), nil
 }
@@ -0,0 +1,151 @@
+package create
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	rigcontext "github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/engine"
+	"github.com/gonstr/rig/pkg/fetch"
+	"github.com/gonstr/rig/pkg/fs"
+	"github.com/gonstr/rig/pkg/metadata"
+	"github.com/gonstr/rig/pkg/version"
+)
+
+// SchemaFileName is the name of the values schema file in a template directory
+const SchemaFileName = "values.schema.json"
+
+const metadataTmpl = `name: {{ .Name }}
+version: {{ .Version }}
+{{- with .Description }}
+description: {{ . | quote }}
+{{- end }}
+{{- with .Keywords }}
+keywords:
+{{ toYaml . | indent 2 }}
+{{- end }}
+{{- with .RigVersion }}
+rigVersion: {{ . | quote }}
+{{- end }}
+`
+
+// New scaffolds a new template in dir
+func New(dir string) error {
+	err := ensureNotExists(dir)
+	if err != nil {
+		return err
+	}
+
+	name := path.Base(dir)
+
+	files := map[string]string{
+		"values.yaml":               fmt.Sprintf(valuesYaml, name, name, name),
+		SchemaFileName:              valuesSchemaJSON,
+		"templates/_helpers.tpl":    helpersTpl,
+		"templates/deployment.yaml": deploymentYaml,
+		"templates/service.yaml":    serviceYaml,
+		"templates/ingress.yaml":    ingressYaml,
+	}
+
+	for filePath, content := range files {
+		err = writeFile(path.Join(dir, filePath), content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeMetadata(dir, &metadata.Metadata{
+		Name:       name,
+		RigVersion: fmt.Sprintf(">= %s", version.Version),
+	})
+}
+
+// FromTemplate scaffolds a new template in dir based on an existing template.
+// The existing template can either be a local template directory or a remote
+// template url. Remote templates are not fetched in offline mode
+func FromTemplate(dir string, from string, offline bool) error {
+	err := ensureNotExists(dir)
+	if err != nil {
+		return err
+	}
+
+	templateDir := from
+
+	if info, err := os.Stat(from); err != nil || !info.IsDir() {
+		tmpl, err := rigcontext.FromURL(from)
+		if err != nil {
+			return err
+		}
+
+		dir, cleanup, err := fetch.Template(context.Background(), tmpl, offline)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+
+		templateDir = dir
+	}
+
+	if !fs.PathExists(path.Join(templateDir, "templates")) {
+		return fmt.Errorf("%s is not a template: templates dir is missing", from)
+	}
+
+	meta, err := metadata.FromDir(templateDir)
+	if err != nil {
+		return err
+	}
+
+	err = fs.CopyDir(templateDir, dir)
+	if err != nil {
+		return err
+	}
+
+	if meta == nil {
+		meta = &metadata.Metadata{}
+	}
+
+	meta.Name = path.Base(dir)
+
+	if !fs.PathExists(path.Join(dir, SchemaFileName)) {
+		err = writeFile(path.Join(dir, SchemaFileName), valuesSchemaJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeMetadata(dir, meta)
+}
+
+// writeMetadata writes a metadata file for a new template. New templates always
+// start at version 0.1.0
+func writeMetadata(dir string, meta *metadata.Metadata) error {
+	tmplData := *meta
+	tmplData.Version = "0.1.0"
+
+	bytes, err := engine.Render(metadataTmpl, tmplData, false)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path.Join(dir, metadata.FileName), string(bytes))
+}
+
+func writeFile(filePath string, content string) error {
+	err := fs.EnsureDir(path.Dir(filePath))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filePath, []byte(content), 0644)
+}
+
+func ensureNotExists(dir string) error {
+	if fs.PathExists(dir) {
+		return fmt.Errorf("%s already exists", dir)
+	}
+
+	return nil
+}
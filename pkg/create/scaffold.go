@@ -0,0 +1,108 @@
+package create
+
+const valuesYaml = `name: %s
+namespace: %s
+
+deployment:
+  replicas: 2
+  image: %s
+  tag: latest
+  port: 8080
+  readinessPath: /health
+
+  resources:
+    requests:
+      cpu: 100m
+      memory: 128Mi
+    limits:
+      cpu: 200m
+      memory: 256Mi
+
+ingress:
+#  host: myapp.mydomain.com
+`
+
+const helpersTpl = `{{- define "labels" -}}
+app: {{ .values.name }}
+{{- end -}}
+`
+
+const deploymentYaml = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+{{ include "labels" . | indent 4 }}
+  name: {{ .values.name }}
+  namespace: {{ .values.namespace }}
+spec:
+  replicas: {{ .values.deployment.replicas }}
+  selector:
+    matchLabels:
+{{ include "labels" . | indent 6 }}
+  template:
+    metadata:
+      labels:
+{{ include "labels" . | indent 8 }}
+    spec:
+      containers:
+      - image: {{ .values.deployment.image }}:{{ .values.deployment.tag }}
+        name: {{ .values.name }}
+        ports:
+        - containerPort: {{ .values.deployment.port }}
+        readinessProbe:
+          httpGet:
+            path: {{ .values.deployment.readinessPath }}
+            port: {{ .values.deployment.port }}
+        resources:
+{{ toYaml .values.deployment.resources | indent 10 }}
+`
+
+const serviceYaml = `apiVersion: v1
+kind: Service
+metadata:
+  labels:
+{{ include "labels" . | indent 4 }}
+  name: {{ .values.name }}
+  namespace: {{ .values.namespace }}
+spec:
+  ports:
+  - port: 80
+    targetPort: {{ .values.deployment.port }}
+  selector:
+{{ include "labels" . | indent 4 }}
+`
+
+const ingressYaml = `{{- if .values.ingress }}
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  labels:
+{{ include "labels" . | indent 4 }}
+  name: {{ .values.name }}
+  namespace: {{ .values.namespace }}
+spec:
+  rules:
+  - host: {{ .values.ingress.host }}
+    http:
+      paths:
+      - backend:
+          serviceName: {{ .values.name }}
+          servicePort: 80
+        path: /
+{{- end }}
+`
+
+const valuesSchemaJSON = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "required": ["name", "namespace"],
+  "properties": {
+    "name": {
+      "type": "string"
+    },
+    "namespace": {
+      "type": "string"
+    }
+  }
+}
+`
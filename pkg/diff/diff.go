@@ -0,0 +1,149 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around changes
+const context = 3
+
+type op struct {
+	kind byte
+	line string
+}
+
+// Unified returns a unified diff of two strings. An empty string is returned
+// if the strings are equal
+func Unified(a string, b string, fromName string, toName string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := lines(split(a), split(b))
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk until there are more than 2*context unchanged lines
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*context; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+
+		for end > start && ops[end-1].kind == ' ' {
+			end--
+		}
+
+		from := max(start-context, 0)
+		to := min(end+context, len(ops))
+
+		aLine, bLine := 1, 1
+		for _, o := range ops[:from] {
+			if o.kind != '+' {
+				aLine++
+			}
+			if o.kind != '-' {
+				bLine++
+			}
+		}
+
+		aCount, bCount := 0, 0
+		for _, o := range ops[from:to] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+
+		for _, o := range ops[from:to] {
+			fmt.Fprintf(&sb, "%c%s\n", o.kind, o.line)
+		}
+
+		start = to
+	}
+
+	return sb.String()
+}
+
+// lines returns the edit script turning a into b based on the longest common
+// subsequence of lines
+func lines(a []string, b []string) []op {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []op
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, op{'+', b[j]})
+			j++
+		default:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		}
+	}
+
+	return ops
+}
+
+func split(str string) []string {
+	if str == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
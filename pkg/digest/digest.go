@@ -0,0 +1,122 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	rigcontext "github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/fetch"
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+// templateLine matches the template key of a rig file
+var templateLine = regexp.MustCompile(`^template:\s*(#.*)?$`)
+
+// keyLine matches an indented key and captures its indentation and name
+var keyLine = regexp.MustCompile(`^([ \t]+)([\w-]+):`)
+
+// FromPath returns the digest of a local template
+func FromPath(templatePath string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	return fs.DirectoryDigest(fetch.TemplatesDir(path.Join(wd, templatePath)))
+}
+
+// FromRigFile returns the current digest of the template in a rig file. Remote
+// templates are not fetched in offline mode
+func FromRigFile(filePath string, offline bool) (string, error) {
+	tmpl, err := rigcontext.FromFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	templateDir, cleanup, err := fetch.Template(context.Background(), tmpl, offline)
+	defer cleanup()
+	if err != nil {
+		return "", err
+	}
+
+	return fs.DirectoryDigest(fetch.TemplatesDir(templateDir))
+}
+
+// UpdateRigFile writes a template digest to a rig file. The rig file is updated
+// in place to keep formatting and comments intact. Only the digest key of the
+// template block is changed
+func UpdateRigFile(filePath string, digest string) error {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(bytes), "\n")
+
+	start := -1
+	for i, line := range lines {
+		if templateLine.MatchString(line) {
+			start = i
+			break
+		}
+	}
+
+	if start == -1 {
+		return fmt.Errorf("%s is malformed: does not contain template", filePath)
+	}
+
+	// Find the digest and the url or path keys directly under template
+	indent := ""
+	digestIndex, urlIndex := -1, -1
+
+	for i := start + 1; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			break
+		}
+
+		m := keyLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		if indent == "" {
+			indent = m[1]
+		}
+
+		if m[1] != indent {
+			continue
+		}
+
+		switch m[2] {
+		case "digest":
+			digestIndex = i
+		case "url", "path":
+			urlIndex = i
+		}
+	}
+
+	line := fmt.Sprintf("%sdigest: %s", indent, digest)
+
+	switch {
+	case digestIndex != -1:
+		lines[digestIndex] = line
+	case urlIndex != -1:
+		lines = append(lines[:urlIndex+1], append([]string{line}, lines[urlIndex+1:]...)...)
+	default:
+		return fmt.Errorf("%s is malformed: does not contain path or url", filePath)
+	}
+
+	return ioutil.WriteFile(filePath, []byte(strings.Join(lines, "\n")), 0644)
+}
@@ -0,0 +1,85 @@
+package digest
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestUpdateRigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		rigFile  string
+		expected string
+	}{
+		{
+			name: "replace",
+			rigFile: `template:
+  url: https://github.com/gonstr/rig-templates//simple-app#simple-app/v1.0.0
+  # pinned
+  digest: sha256v2:old
+
+values:
+  image:
+    digest: sha256:image
+`,
+			expected: `template:
+  url: https://github.com/gonstr/rig-templates//simple-app#simple-app/v1.0.0
+  # pinned
+  digest: sha256v2:new
+
+values:
+  image:
+    digest: sha256:image
+`,
+		},
+		{
+			name: "insert",
+			rigFile: `values:
+  digest: sha256:value
+template:
+  path: ./tmpl
+  trustedKeys:
+    - digest: x
+`,
+			expected: `values:
+  digest: sha256:value
+template:
+  path: ./tmpl
+  digest: sha256v2:new
+  trustedKeys:
+    - digest: x
+`,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "rig-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		filePath := path.Join(dir, test.name+".yaml")
+
+		err := ioutil.WriteFile(filePath, []byte(test.rigFile), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = UpdateRigFile(filePath, "sha256v2:new")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		bytes, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(bytes) != test.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, test.expected, bytes)
+		}
+	}
+}
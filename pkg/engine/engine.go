@@ -3,7 +3,6 @@ package engine
 import (
 	"bytes"
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 	"text/template"
@@ -14,6 +13,12 @@ import (
 
 // FuncMap returns funcmap for use in go templating
 func FuncMap() template.FuncMap {
+	return funcMap(nil)
+}
+
+// funcMap returns funcmap for use in go templating. The env functions only
+// read variables allowed by the policy
+func funcMap(policy *EnvPolicy) template.FuncMap {
 	f := sprig.TxtFuncMap()
 
 	// Add some extra functionality
@@ -24,21 +29,12 @@ func FuncMap() template.FuncMap {
 		"toJson":   chartutil.ToJson,
 		"fromJson": chartutil.FromJson,
 
-		// We want to error on env or expandenv if the env values does no exist
-		"env": func(s string) (string, error) {
-			e := os.Getenv(s)
-			if e == "" {
-				return "", fmt.Errorf("Environment variable '%s' does not exists", s)
-			}
-			return e, nil
-		},
-		"expandenv": func(s string) (string, error) {
-			e := os.ExpandEnv(s)
-			if e == "" {
-				return "", fmt.Errorf("Environment variable '%s' does not exists", s)
-			}
-			return e, nil
-		},
+		// We want to error on env or expandenv if the env values does no exist.
+		// envOr and optionalEnv are used for variables that can be unset
+		"env":         policy.env,
+		"expandenv":   policy.expandEnv,
+		"envOr":       policy.envOr,
+		"optionalEnv": policy.optionalEnv,
 	}
 
 	for k, v := range extra {
@@ -48,18 +44,46 @@ func FuncMap() template.FuncMap {
 	return f
 }
 
+// preProcess prepares a string for go templating
+func preProcess(str string) string {
+	// Go templates fails to render funny unicode characters to we replace any non
+	// ascii characters with an empty string for now.
+	// TODO: Improve this, we probably only want to replace problematic unicode
+	// characters instead of all non ascii.
+	return onlyASCII.ReplaceAllLiteralString(str, "")
+}
+
 var emptyLines = regexp.MustCompile(`(?m)^\s*$[\r\n]*|[\r\n]+\s+\z`)
 var onlyASCII = regexp.MustCompile("[[:^ascii:]]")
 
 // Render a tmp string with templates values
 func Render(str string, vals interface{}, removeEmptyLines bool) ([]byte, error) {
-	// Go templates fails to render funny unicode characters to we replace any non
-	// ascii characters with an empty string for now.
-	// TODO: Improve this, we probably only want to replace problematic unicode
-	// characters instead of all non ascii.
-	preProcessedStr := onlyASCII.ReplaceAllLiteralString(str, "")
+	return RenderWithPartials(str, nil, vals, removeEmptyLines, nil)
+}
+
+// RenderWithPartials renders a tmp string with template values. Templates
+// defined in partials can be used in the tmp string with the template action or
+// the include function. If policy is not nil, templates can only read the
+// environment variables it allows
+func RenderWithPartials(str string, partials []string, vals interface{}, removeEmptyLines bool, policy *EnvPolicy) ([]byte, error) {
+	tmpl := template.New("tmpl").Option("missingkey=error")
+
+	funcMap := funcMap(policy)
+	funcMap["include"] = func(name string, data interface{}) (string, error) {
+		var buffer bytes.Buffer
+		err := tmpl.ExecuteTemplate(&buffer, name, data)
+		return buffer.String(), err
+	}
+	tmpl.Funcs(funcMap)
+
+	for i, partial := range partials {
+		_, err := tmpl.New(fmt.Sprintf("partial%d", i)).Parse(preProcess(partial))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	tmpl, err := template.New("tmpl").Option("missingkey=error").Funcs(FuncMap()).Parse(preProcessedStr)
+	_, err := tmpl.Parse(preProcess(str))
 	if err != nil {
 		return nil, err
 	}
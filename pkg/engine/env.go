@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// EnvPolicy restricts the environment variables templates can read with env,
+// expandenv, envOr and optionalEnv. Reading a variable that is not allowed is
+// an error. A nil policy allows all variables
+type EnvPolicy struct {
+	// Allow lists the variables templates can read. Names can be patterns
+	// such as APP_*
+	Allow []string
+}
+
+// Allowed returns true if templates can read a variable
+func (p *EnvPolicy) Allowed(name string) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, pattern := range p.Allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getenv returns the value of an environment variable. Empty variables are
+// treated as unset
+func (p *EnvPolicy) getenv(name string) (string, error) {
+	if !p.Allowed(name) {
+		return "", deniedError([]string{name})
+	}
+
+	return os.Getenv(name), nil
+}
+
+func (p *EnvPolicy) env(name string) (string, error) {
+	e, err := p.getenv(name)
+	if err != nil {
+		return "", err
+	}
+
+	if e == "" {
+		return "", fmt.Errorf("Environment variable '%s' does not exists", name)
+	}
+
+	return e, nil
+}
+
+// envOr returns the value of an environment variable or def if it is unset
+func (p *EnvPolicy) envOr(name string, def string) (string, error) {
+	e, err := p.getenv(name)
+	if err != nil || e != "" {
+		return e, err
+	}
+
+	return def, nil
+}
+
+func (p *EnvPolicy) optionalEnv(name string) (string, error) {
+	return p.getenv(name)
+}
+
+// expandEnv replaces $var and ${var} in a string with the values of
+// environment variables. All denied and unset variables are reported
+func (p *EnvPolicy) expandEnv(s string) (string, error) {
+	var denied, unset []string
+
+	e := os.Expand(s, func(name string) string {
+		if !p.Allowed(name) {
+			denied = appendUnique(denied, name)
+			return ""
+		}
+
+		value := os.Getenv(name)
+		if value == "" {
+			unset = appendUnique(unset, name)
+		}
+		return value
+	})
+
+	if len(denied) > 0 {
+		return "", deniedError(denied)
+	}
+
+	if len(unset) == 1 {
+		return "", fmt.Errorf("Environment variable '%s' does not exists", unset[0])
+	}
+
+	if len(unset) > 1 {
+		return "", fmt.Errorf("Environment variables '%s' do not exist", strings.Join(unset, "', '"))
+	}
+
+	return e, nil
+}
+
+func deniedError(names []string) error {
+	variables := "environment variable"
+	if len(names) > 1 {
+		variables += "s"
+	}
+
+	return fmt.Errorf("Template is not allowed to read %s '%s'. Allow access with allowEnv in the template section of rig.yaml or with --allow-env %s", variables, strings.Join(names, "', '"), strings.Join(names, ","))
+}
+
+func appendUnique(list []string, str string) []string {
+	for _, item := range list {
+		if item == str {
+			return list
+		}
+	}
+	return append(list, str)
+}
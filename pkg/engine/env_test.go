@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// setenv sets the variables read by the tests. The returned func unsets them
+func setenv() func() {
+	os.Setenv("RIG_TEST_APP", "app")
+	os.Setenv("RIG_TEST_TAG", "v1")
+	os.Setenv("RIG_TEST_SECRET", "secret")
+	os.Unsetenv("RIG_TEST_UNSET")
+	os.Unsetenv("RIG_TEST_OTHER")
+
+	return func() {
+		for _, name := range []string{"RIG_TEST_APP", "RIG_TEST_TAG", "RIG_TEST_SECRET"} {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func render(str string, policy *EnvPolicy) (string, error) {
+	out, err := RenderWithPartials(str, nil, nil, false, policy)
+	return string(out), err
+}
+
+func TestEnv(t *testing.T) {
+	defer setenv()()
+
+	policy := &EnvPolicy{Allow: []string{"RIG_TEST_APP", "RIG_TEST_TAG", "RIG_TEST_UNSET", "RIG_TEST_OTHER"}}
+
+	for _, test := range []struct {
+		tmpl     string
+		expected string
+		err      string
+	}{
+		{`{{ env "RIG_TEST_APP" }}`, "app", ""},
+		{`{{ env "RIG_TEST_UNSET" }}`, "", "Environment variable 'RIG_TEST_UNSET' does not exists"},
+		{`{{ env "RIG_TEST_SECRET" }}`, "", "not allowed to read environment variable 'RIG_TEST_SECRET'"},
+		{`{{ envOr "RIG_TEST_APP" "default" }}`, "app", ""},
+		{`{{ envOr "RIG_TEST_UNSET" "default" }}`, "default", ""},
+		{`{{ envOr "RIG_TEST_SECRET" "default" }}`, "", "not allowed to read environment variable 'RIG_TEST_SECRET'"},
+		{`{{ optionalEnv "RIG_TEST_APP" }}`, "app", ""},
+		{`{{ optionalEnv "RIG_TEST_UNSET" }}`, "", ""},
+		{`{{ optionalEnv "RIG_TEST_SECRET" }}`, "", "not allowed to read environment variable 'RIG_TEST_SECRET'"},
+		{`{{ expandenv "$RIG_TEST_APP:${RIG_TEST_TAG}" }}`, "app:v1", ""},
+		{`{{ expandenv "$RIG_TEST_UNSET" }}`, "", "Environment variable 'RIG_TEST_UNSET' does not exists"},
+		{`{{ expandenv "$RIG_TEST_UNSET-$RIG_TEST_OTHER-$RIG_TEST_UNSET" }}`, "", "Environment variables 'RIG_TEST_UNSET', 'RIG_TEST_OTHER' do not exist"},
+		{`{{ expandenv "$RIG_TEST_SECRET-$HOME-$RIG_TEST_UNSET" }}`, "", "not allowed to read environment variables 'RIG_TEST_SECRET', 'HOME'. Allow access with allowEnv in the template section of rig.yaml or with --allow-env RIG_TEST_SECRET,HOME"},
+	} {
+		out, err := render(test.tmpl, policy)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing '%s', got %v", test.tmpl, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.tmpl, err)
+			continue
+		}
+
+		if out != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.tmpl, test.expected, out)
+		}
+	}
+}
+
+func TestEnvPolicy(t *testing.T) {
+	defer setenv()()
+
+	// A nil policy allows all variables
+	out, err := render(`{{ env "RIG_TEST_SECRET" }}`, nil)
+	if err != nil || out != "secret" {
+		t.Errorf("expected a nil policy to allow all variables, got '%s' %v", out, err)
+	}
+
+	// An empty policy denies all variables
+	_, err = render(`{{ env "RIG_TEST_APP" }}`, &EnvPolicy{})
+	if err == nil {
+		t.Error("expected an empty policy to deny all variables")
+	}
+
+	policy := &EnvPolicy{Allow: []string{"RIG_TEST_A*"}}
+
+	for name, expected := range map[string]bool{
+		"RIG_TEST_APP":    true,
+		"RIG_TEST_ANY":    true,
+		"RIG_TEST_SECRET": false,
+		"RIG_TEST":        false,
+	} {
+		if policy.Allowed(name) != expected {
+			t.Errorf("%s: expected allowed to be %t", name, expected)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package fetch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonstr/rig/pkg/archive"
+	rigcontext "github.com/gonstr/rig/pkg/context"
+)
+
+// archiveServer packages a template and serves it at /templates/simple-app-1.0.0.tgz.
+// Requests must have the basic auth token if token is not empty
+func archiveServer(t *testing.T, dir string, token string) *httptest.Server {
+	templateDir := path.Join(dir, "simple-app")
+
+	for name, content := range map[string]string{
+		"rig-template.yaml":      "name: simple-app\nversion: 1.0.0\n",
+		"templates/service.yaml": "kind: Service\n",
+	} {
+		filePath := path.Join(templateDir, name)
+
+		err := os.MkdirAll(path.Dir(filePath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath, _, err := archive.Package(templateDir, path.Join(dir, "dist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/templates/"+path.Base(archivePath) {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeFile(w, r, archivePath)
+	}))
+}
+
+func archiveContext(t *testing.T, server *httptest.Server) rigcontext.Context {
+	tmpl, err := rigcontext.FromURL(server.URL + "/templates/simple-app-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tmpl
+}
+
+func TestDownload(t *testing.T) {
+	dir, teardown := setup(t)
+	defer teardown()
+
+	server := archiveServer(t, dir, "token")
+	defer server.Close()
+
+	tmpl := archiveContext(t, server)
+
+	_, cleanup, err := Template(context.Background(), tmpl, true)
+	cleanup()
+	if err == nil || !strings.Contains(err.Error(), "is not cached. Run without --offline to fetch it") {
+		t.Fatalf("expected an archive that is not downloaded to fail offline, got %v", err)
+	}
+
+	_, cleanup, err = Template(context.Background(), tmpl, false)
+	cleanup()
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Fatalf("expected a download without credentials to fail, got %v", err)
+	}
+
+	os.Setenv(tokenEnv(tmpl.Host()), "token")
+	defer os.Unsetenv(tokenEnv(tmpl.Host()))
+
+	templateDir, cleanup, err := Template(context.Background(), tmpl, false)
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(templateDir, "templates", "service.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "kind: Service\n" {
+		t.Errorf("expected the template files, got %s", content)
+	}
+
+	// The downloaded archive is used offline
+	server.Close()
+
+	_, offlineCleanup, err := Template(context.Background(), tmpl, true)
+	defer offlineCleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	_, teardown := setup(t)
+	defer teardown()
+
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := httpClient
+	httpClient = &http.Client{Timeout: 100 * time.Millisecond}
+	defer func() { httpClient = client }()
+
+	_, cleanup, err := Template(context.Background(), archiveContext(t, server), false)
+	defer cleanup()
+	if err == nil || !strings.Contains(err.Error(), "Unable to download") {
+		t.Errorf("expected a stalled download to time out, got %v", err)
+	}
+}
@@ -0,0 +1,535 @@
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/gonstr/rig/pkg/archive"
+	"github.com/gonstr/rig/pkg/cache"
+	"github.com/gonstr/rig/pkg/config"
+	rigcontext "github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/fs"
+	"github.com/gonstr/rig/pkg/git"
+	"github.com/gonstr/rig/pkg/oci"
+)
+
+// DownloadTimeout is the timeout of template archive downloads, including
+// reading the archive
+const DownloadTimeout = 5 * time.Minute
+
+// httpClient downloads template archives
+var httpClient = &http.Client{Timeout: DownloadTimeout}
+
+// Template returns the template dir of a context. Local templates are resolved
+// relative to the current directory. Remote templates are synced and checked
+// out to a temp dir. In offline mode the cached repository is used as is.
+// Fetching is stopped if ctx is done. The returned cleanup func removes any
+// temp files and must always be called
+func Template(ctx context.Context, tmpl rigcontext.Context, offline bool) (string, func(), error) {
+	noop := func() {}
+
+	if tmpl.Scheme() == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", noop, err
+		}
+
+		return path.Join(wd, tmpl.Path()), noop, nil
+	}
+
+	if tmpl.Scheme() == "oci" {
+		return ociTemplate(ctx, tmpl, offline)
+	}
+
+	if tmpl.Archive() {
+		return archiveTemplate(ctx, tmpl, offline)
+	}
+
+	repoDir, err := tmpl.RepoDir()
+	if err != nil {
+		return "", noop, err
+	}
+
+	gitURL, err := tmpl.RepoURL()
+	if err != nil {
+		return "", noop, err
+	}
+
+	creds, err := Credentials(tmpl.Host())
+	if err != nil {
+		return "", noop, err
+	}
+
+	unlock, err := cache.Lock(ctx, repoDir, cache.LockTimeout)
+	if err != nil {
+		return "", noop, err
+	}
+
+	defer unlock()
+
+	err = sync(ctx, tmpl, repoDir, gitURL, creds, offline)
+	if err != nil {
+		return "", noop, authHelp(tmpl, err)
+	}
+
+	err = cache.Touch(repoDir)
+	if err != nil {
+		return "", noop, err
+	}
+
+	tmpDir, err := fs.TempDir()
+	if err != nil {
+		return "", noop, err
+	}
+
+	cleanup := func() {
+		os.RemoveAll(tmpDir)
+	}
+
+	err = git.Checkout(ctx, repoDir, tmpDir, tmpl.Gitref(), tmpl.Path(), gitURL, creds)
+	if err != nil {
+		cleanup()
+		return "", noop, authHelp(tmpl, err)
+	}
+
+	return path.Join(tmpDir, tmpl.Path()), cleanup, nil
+}
+
+// archiveTemplate extracts the template archive of a context to a temp dir.
+// Remote archives are downloaded to the cache first. In offline mode the cached
+// archive is used
+func archiveTemplate(ctx context.Context, tmpl rigcontext.Context, offline bool) (string, func(), error) {
+	noop := func() {}
+
+	archiveURL, err := tmpl.RepoURL()
+	if err != nil {
+		return "", noop, err
+	}
+
+	archivePath := strings.TrimPrefix(archiveURL, "file://")
+
+	if tmpl.Scheme() != "file" {
+		archivePath, err = tmpl.RepoDir()
+		if err != nil {
+			return "", noop, err
+		}
+
+		unlock, err := cache.Lock(ctx, archivePath, cache.LockTimeout)
+		if err != nil {
+			return "", noop, err
+		}
+
+		defer unlock()
+
+		if offline {
+			if !fs.PathExists(archivePath) {
+				return "", noop, fmt.Errorf("Template archive %s is not cached. Run without --offline to fetch it", archiveURL)
+			}
+		} else {
+			err = download(ctx, tmpl, archiveURL, archivePath)
+			if err != nil {
+				return "", noop, err
+			}
+		}
+
+		err = cache.Touch(archivePath)
+		if err != nil {
+			return "", noop, err
+		}
+	}
+
+	tmpDir, err := fs.TempDir()
+	if err != nil {
+		return "", noop, err
+	}
+
+	cleanup := func() {
+		os.RemoveAll(tmpDir)
+	}
+
+	templateDir, err := archive.Extract(archivePath, tmpDir)
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+
+	return templateDir, cleanup, nil
+}
+
+// download downloads a template archive. Configured credentials for the host
+// are sent with basic auth
+func download(ctx context.Context, tmpl rigcontext.Context, archiveURL string, archivePath string) error {
+	creds, err := Credentials(tmpl.Host())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, archiveURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+
+	if creds.Token != "" {
+		req.SetBasicAuth(creds.Username, creds.Token)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to download %s: %s", archiveURL, err)
+	}
+
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("Unable to download %s: %s\n\n%s", archiveURL, res.Status, CredentialsHelp(tmpl.Host()))
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("Unable to download %s: %s", archiveURL, res.Status)
+	}
+
+	err = fs.EnsureDir(path.Dir(archivePath))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := archivePath + ".download"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, res.Body)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Unable to download %s: %s", archiveURL, err)
+	}
+
+	return os.Rename(tmpPath, archivePath)
+}
+
+// Pin returns a context of an oci template that is pinned to the manifest its
+// tag currently points to. Contexts that are already pinned or that are not oci
+// templates are returned as is
+func Pin(ctx context.Context, tmpl rigcontext.Context, offline bool) (rigcontext.Context, error) {
+	if tmpl.Scheme() != "oci" || tmpl.ManifestDigest() != "" {
+		return tmpl, nil
+	}
+
+	repoDir, err := tmpl.RepoDir()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ociClient(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := cache.Lock(ctx, repoDir, cache.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	manifestDigest, err := ociManifest(ctx, tmpl, client, oci.Layout{Dir: repoDir}, offline)
+	if err != nil {
+		return nil, authHelp(tmpl, err)
+	}
+
+	fullURL, err := tmpl.URL()
+	if err != nil {
+		return nil, err
+	}
+
+	return rigcontext.FromURL(fmt.Sprintf("%s@%s", fullURL, manifestDigest))
+}
+
+// ociTemplate pulls the template of an oci context to the cache and extracts
+// it to a temp dir. In offline mode the cached template is used
+func ociTemplate(ctx context.Context, tmpl rigcontext.Context, offline bool) (string, func(), error) {
+	noop := func() {}
+
+	repoDir, err := tmpl.RepoDir()
+	if err != nil {
+		return "", noop, err
+	}
+
+	client, err := ociClient(tmpl)
+	if err != nil {
+		return "", noop, err
+	}
+
+	unlock, err := cache.Lock(ctx, repoDir, cache.LockTimeout)
+	if err != nil {
+		return "", noop, err
+	}
+
+	defer unlock()
+
+	layout := oci.Layout{Dir: repoDir}
+
+	manifestDigest, err := ociManifest(ctx, tmpl, client, layout, offline)
+	if err != nil {
+		return "", noop, authHelp(tmpl, err)
+	}
+
+	bytes, err := layout.ReadBlob(manifestDigest)
+	if err != nil {
+		return "", noop, err
+	}
+
+	var manifest oci.Manifest
+
+	err = json.Unmarshal(bytes, &manifest)
+	if err != nil {
+		return "", noop, fmt.Errorf("Manifest %s is malformed: %s", manifestDigest, err)
+	}
+
+	layer, err := manifest.Layer()
+	if err != nil {
+		return "", noop, err
+	}
+
+	if !layout.HasBlob(layer.Digest) {
+		if offline {
+			return "", noop, fmt.Errorf("Template %s is not cached. Run without --offline to fetch it", manifestDigest)
+		}
+
+		blob, err := client.Blob(ctx, layer.Digest)
+		if err != nil {
+			return "", noop, authHelp(tmpl, err)
+		}
+
+		err = layout.WriteBlob(layer.Digest, blob)
+		if err != nil {
+			return "", noop, err
+		}
+	}
+
+	err = cache.Touch(repoDir)
+	if err != nil {
+		return "", noop, err
+	}
+
+	tmpDir, err := fs.TempDir()
+	if err != nil {
+		return "", noop, err
+	}
+
+	cleanup := func() {
+		os.RemoveAll(tmpDir)
+	}
+
+	err = layout.VerifyBlob(layer.Digest)
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+
+	templateDir, err := archive.Extract(layout.BlobPath(layer.Digest), tmpDir)
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+
+	return templateDir, cleanup, nil
+}
+
+// ociManifest makes sure the cached layout of an oci context contains the
+// manifest of the context and returns its digest. Pinned manifests that are
+// already cached are never fetched. Tags are always resolved against the
+// registry unless in offline mode
+func ociManifest(ctx context.Context, tmpl rigcontext.Context, client *oci.Client, layout oci.Layout, offline bool) (string, error) {
+	fullURL, err := tmpl.URL()
+	if err != nil {
+		return "", err
+	}
+
+	pinned := tmpl.ManifestDigest()
+
+	if pinned != "" && layout.HasBlob(pinned) {
+		return pinned, nil
+	}
+
+	if offline {
+		if pinned != "" {
+			return "", fmt.Errorf("Template %s is not cached. Run without --offline to fetch it", fullURL)
+		}
+
+		manifestDigest, err := layout.Resolve(tmpl.Gitref())
+		if err != nil || !layout.HasBlob(manifestDigest) {
+			return "", fmt.Errorf("Template %s is not cached. Run without --offline to fetch it", fullURL)
+		}
+
+		return manifestDigest, nil
+	}
+
+	reference := tmpl.Gitref()
+	if pinned != "" {
+		reference = pinned
+	}
+
+	manifest, manifestDigest, err := client.Manifest(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+
+	err = layout.WriteBlob(manifestDigest, manifest)
+	if err != nil {
+		return "", err
+	}
+
+	if pinned == "" {
+		err = layout.Tag(tmpl.Gitref(), oci.Descriptor{MediaType: oci.ManifestMediaType, Digest: manifestDigest, Size: int64(len(manifest))})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return manifestDigest, nil
+}
+
+// ociClient returns a registry client for an oci context
+func ociClient(tmpl rigcontext.Context) (*oci.Client, error) {
+	creds, err := Credentials(tmpl.Host())
+	if err != nil {
+		return nil, err
+	}
+
+	return &oci.Client{
+		Host:       tmpl.Host(),
+		Repository: path.Join(tmpl.Owner(), tmpl.Repo()),
+		Username:   creds.Username,
+		Token:      creds.Token,
+	}, nil
+}
+
+// TemplatesDir returns the dir containing the template files of a template
+// dir. Local template paths may point directly to the template files
+func TemplatesDir(templateDir string) string {
+	templatesDir := path.Join(templateDir, "templates")
+
+	if fs.PathExists(templatesDir) {
+		return templatesDir
+	}
+
+	return templateDir
+}
+
+// sync makes sure the cached repository of a context contains the template
+// ref. Nothing is fetched in offline mode or when the ref is a commit sha that
+// is already cached
+func sync(ctx context.Context, tmpl rigcontext.Context, repoDir string, gitURL string, creds *git.Credentials, offline bool) error {
+	cached := fs.PathExists(repoDir)
+
+	if offline {
+		if !cached {
+			return fmt.Errorf("Template repository %s is not cached. Run without --offline to fetch it", gitURL)
+		}
+
+		if _, err := git.ResolveRef(repoDir, tmpl.Gitref()); err != nil {
+			return fmt.Errorf("Ref %s of %s is not cached. Run without --offline to fetch it", tmpl.Gitref(), gitURL)
+		}
+
+		return nil
+	}
+
+	if cached && git.IsCommitSHA(tmpl.Gitref()) {
+		if _, err := git.ResolveRef(repoDir, tmpl.Gitref()); err == nil {
+			return nil
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	return git.Sync(ctx, repoDir, gitURL, tmpl.Gitref(), creds, cfg.FullFetch)
+}
+
+// authHelp adds instructions on how to configure credentials to auth errors
+func authHelp(tmpl rigcontext.Context, err error) error {
+	switch err.(type) {
+	case *git.AuthError, *oci.AuthError:
+		return fmt.Errorf("%s\n\n%s", err, CredentialsHelp(tmpl.Host()))
+	}
+
+	return err
+}
+
+// CredentialsHelp returns instructions on how to configure credentials for a
+// host
+func CredentialsHelp(host string) string {
+	return fmt.Sprintf("Configure credentials for %s in ~/.rig/credentials.yaml or set the %s environment variable", host, tokenEnv(host))
+}
+
+// Credentials returns the credentials for a host. Tokens are read from the
+// RIG_GIT_TOKEN_<HOST> environment variable or from ~/.rig/credentials.yaml
+func Credentials(host string) (*git.Credentials, error) {
+	cfg, err := config.LoadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	hostCreds, ok := cfg.Hosts[host]
+	if !ok {
+		hostCreds = cfg.Hosts[strings.Split(host, ":")[0]]
+	}
+
+	creds := git.Credentials{
+		Username: hostCreds.Username,
+		Token:    hostCreds.Token,
+		SSHKey:   hostCreds.SSHKey,
+	}
+
+	if hostCreds.TokenEnv != "" {
+		creds.Token = os.Getenv(hostCreds.TokenEnv)
+		if creds.Token == "" {
+			return nil, fmt.Errorf("Environment variable '%s' configured as token for %s does not exists", hostCreds.TokenEnv, host)
+		}
+	}
+
+	if token := os.Getenv(tokenEnv(host)); token != "" {
+		creds.Token = token
+	}
+
+	if creds.Username == "" {
+		creds.Username = "x-access-token"
+	}
+
+	if creds.SSHKey != "" {
+		creds.SSHKey, err = homedir.Expand(creds.SSHKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &creds, nil
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// tokenEnv returns the name of the token environment variable for a host
+func tokenEnv(host string) string {
+	return "RIG_GIT_TOKEN_" + nonAlphanumeric.ReplaceAllString(strings.ToUpper(host), "_")
+}
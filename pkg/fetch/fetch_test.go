@@ -0,0 +1,405 @@
+package fetch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	stdsync "sync"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/gonstr/rig/pkg/cache"
+	rigcontext "github.com/gonstr/rig/pkg/context"
+)
+
+// setup isolates the rig config and cache in a temp dir and returns it. The
+// returned cleanup func removes the dir and restores the environment
+func setup(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "rig-fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := make(map[string]func())
+	for _, key := range []string{"HOME", "RIG_CACHE_DIR"} {
+		key := key
+		if value, ok := os.LookupEnv(key); ok {
+			restore[key] = func() { os.Setenv(key, value) }
+		} else {
+			restore[key] = func() { os.Unsetenv(key) }
+		}
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+	os.Setenv("RIG_CACHE_DIR", path.Join(dir, "cache"))
+
+	return dir, func() {
+		for _, f := range restore {
+			f()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// gitRepo creates a git repository with files and returns its path
+func gitRepo(t testing.TB, dir string, files map[string]string) string {
+	repoDir := path.Join(dir, "templates")
+
+	for name, content := range files {
+		filePath := path.Join(repoDir, name)
+
+		err := os.MkdirAll(path.Dir(filePath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runGit(t, repoDir, "init", "-q")
+	runGit(t, repoDir, "checkout", "-q", "-b", "master")
+	runGit(t, repoDir, "add", "-A")
+	runGit(t, repoDir, "commit", "-q", "-m", "templates")
+
+	return repoDir
+}
+
+func runGit(t testing.TB, dir string, args ...string) {
+	args = append([]string{"-c", "user.name=rig", "-c", "user.email=rig@example.com"}, args...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s\n%s", args, err, out)
+	}
+}
+
+func TestTemplateConcurrent(t *testing.T) {
+	dir, teardown := setup(t)
+	defer teardown()
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/deployment.yaml": "kind: Deployment\n",
+	})
+
+	tmpl, err := rigcontext.FromURL("file://" + repoDir + "//simple-app#master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg stdsync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			templateDir, cleanup, err := Template(context.Background(), tmpl, false)
+			defer cleanup()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			_, err = ioutil.ReadFile(path.Join(templateDir, "templates", "deployment.yaml"))
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// helper runs TestHelperProcess in a new process of the test binary. The
+// process fetches url or, with mode lock, holds the lock of its cached repo
+func helper(mode string, url string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "RIG_TEST_HELPER="+mode, "RIG_TEST_URL="+url)
+	return cmd
+}
+
+// TestHelperProcess is not a test. It is run by helper in another process
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv("RIG_TEST_HELPER")
+	if mode == "" {
+		return
+	}
+
+	homedir.DisableCache = true
+
+	err := helperProcess(mode, os.Getenv("RIG_TEST_URL"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func helperProcess(mode string, url string) error {
+	tmpl, err := rigcontext.FromURL(url)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "template":
+		templateDir, cleanup, err := Template(context.Background(), tmpl, false)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+
+		_, err = ioutil.ReadFile(path.Join(templateDir, "templates", "deployment.yaml"))
+		return err
+	case "lock":
+		repoDir, err := tmpl.RepoDir()
+		if err != nil {
+			return err
+		}
+
+		_, err = cache.Lock(context.Background(), repoDir, 0)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("locked")
+
+		// Hold the lock until the process is killed
+		time.Sleep(time.Hour)
+	}
+
+	return fmt.Errorf("Unknown helper mode %s", mode)
+}
+
+func TestTemplateProcesses(t *testing.T) {
+	dir, teardown := setup(t)
+	defer teardown()
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/deployment.yaml": "kind: Deployment\n",
+	})
+
+	url := "file://" + repoDir + "//simple-app#master"
+
+	var cmds []*exec.Cmd
+	for i := 0; i < 4; i++ {
+		cmd := helper("template", url)
+
+		err := cmd.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	for _, cmd := range cmds {
+		err := cmd.Wait()
+		if err != nil {
+			t.Errorf("Concurrent fetch failed: %s", err)
+		}
+	}
+}
+
+func TestTemplateWaitsForLock(t *testing.T) {
+	dir, teardown := setup(t)
+	defer teardown()
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/deployment.yaml": "kind: Deployment\n",
+	})
+
+	url := "file://" + repoDir + "//simple-app#master"
+
+	tmpl, err := rigcontext.FromURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := helper("lock", url)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cmd.Process.Kill()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "locked\n" {
+		t.Fatalf("Helper did not lock: %q %v", line, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	_, cleanup, err := Template(ctx, tmpl, false)
+	cleanup()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected fetching to wait for the lock of another process, got %v", err)
+	}
+
+	// The lock of a crashed process is released by the OS
+	err = cmd.Process.Kill()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.Wait()
+
+	_, cleanup, err = Template(context.Background(), tmpl, false)
+	cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTemplate fetches a template and returns the content of its
+// deployment.yaml
+func readTemplate(tmpl rigcontext.Context, offline bool) (string, error) {
+	templateDir, cleanup, err := Template(context.Background(), tmpl, offline)
+	defer cleanup()
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ioutil.ReadFile(path.Join(templateDir, "templates", "deployment.yaml"))
+	return string(bytes), err
+}
+
+func TestTemplateOffline(t *testing.T) {
+	dir, teardown := setup(t)
+	defer teardown()
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/deployment.yaml": "replicas: 1\n",
+	})
+
+	tmpl, err := rigcontext.FromURL("file://" + repoDir + "//simple-app#master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readTemplate(tmpl, true)
+	if err == nil || !strings.Contains(err.Error(), "is not cached. Run without --offline to fetch it") {
+		t.Fatalf("Expected an uncached repository to fail offline, got %v", err)
+	}
+
+	_, err = readTemplate(tmpl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path.Join(repoDir, "simple-app", "templates", "deployment.yaml"), []byte("replicas: 2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runGit(t, repoDir, "commit", "-q", "-a", "-m", "replicas")
+	runGit(t, repoDir, "tag", "v2")
+
+	// The cached commit of master is used without fetching the new one
+	content, err := readTemplate(tmpl, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content != "replicas: 1\n" {
+		t.Errorf("Expected the cached template offline, got %s", content)
+	}
+
+	tagged, err := rigcontext.FromURL("file://" + repoDir + "//simple-app#v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readTemplate(tagged, true)
+	if err == nil || !strings.Contains(err.Error(), "Ref v2 of") {
+		t.Errorf("Expected an uncached ref to fail offline, got %v", err)
+	}
+
+	content, err = readTemplate(tmpl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content != "replicas: 2\n" {
+		t.Errorf("Expected the latest template online, got %s", content)
+	}
+}
+
+func TestTemplateCachedCommit(t *testing.T) {
+	dir, teardown := setup(t)
+	defer teardown()
+
+	repoDir := gitRepo(t, dir, map[string]string{
+		"simple-app/templates/deployment.yaml": "replicas: 1\n",
+	})
+
+	out, err := exec.Command("git", "-C", repoDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned, err := rigcontext.FromURL("file://" + repoDir + "//simple-app#" + strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master, err := rigcontext.FromURL("file://" + repoDir + "//simple-app#master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readTemplate(pinned, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make every fetch fail
+	err = os.Rename(repoDir, repoDir+".moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := readTemplate(pinned, false)
+	if err != nil {
+		t.Fatalf("Expected a cached commit to be used without fetching: %s", err)
+	}
+
+	if content != "replicas: 1\n" {
+		t.Errorf("Unexpected template %s", content)
+	}
+
+	_, err = readTemplate(master, false)
+	if err == nil {
+		t.Error("Expected a branch to be fetched")
+	}
+}
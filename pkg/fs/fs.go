@@ -8,6 +8,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/gonstr/rig/pkg/engine"
@@ -42,15 +44,144 @@ func TempDir() (string, error) {
 	return dir, nil
 }
 
-// DirectoryDigest returns a sha 256 digest of all files in a directory
-func DirectoryDigest(path string) (string, error) {
+const (
+	digestV1Prefix = "sha256:"
+	digestV2Prefix = "sha256v2:"
+)
+
+// DirectoryDigest returns a sha256v2 digest of a directory. The digest covers
+// the relative path, type, mode and contents of every file in the directory.
+// Files and dirs with an excluded relative path are skipped
+func DirectoryDigest(dir string, exclude ...string) (string, error) {
+	return DirectoryDigestWith(dir, DigestOptions{
+		Skip: func(rel string, info os.FileInfo) bool {
+			for _, e := range exclude {
+				if rel == e {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
+
+// DigestOptions change which files DirectoryDigestWith hashes and how
+type DigestOptions struct {
+	// Skip returns true for files and dirs that are left out of the digest.
+	// Skipped dirs are left out with all their files
+	Skip func(rel string, info os.FileInfo) bool
+	// Content returns the content that is hashed for a regular file
+	Content func(rel string, content []byte) []byte
+}
+
+// DirectoryDigestWith returns a sha256v2 digest of a directory like
+// DirectoryDigest with the files and contents selected by opts
+func DirectoryDigestWith(dir string, opts DigestOptions) (string, error) {
 	hash := sha256.New()
 
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	var paths []string
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
 		if err != nil {
+			return err
+		}
+
+		if rel != "." && opts.Skip != nil && opts.Skip(filepath.ToSlash(rel), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
+		paths = append(paths, filePath)
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	entries := make(map[string]string)
+	var rels []string
+
+	for _, filePath := range paths {
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return "", err
+		}
+
+		rel = filepath.ToSlash(rel)
+		entries[rel] = filePath
+		rels = append(rels, rel)
+	}
+
+	sort.Strings(rels)
+
+	for _, rel := range rels {
+		filePath := entries[rel]
+
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return "", err
+		}
+
+		var kind string
+		var content []byte
+
+		switch {
+		case info.IsDir():
+			kind = "d"
+		case info.Mode()&os.ModeSymlink != 0:
+			kind = "l"
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return "", err
+			}
+			content = []byte(filepath.ToSlash(target))
+		case info.Mode().IsRegular():
+			kind = "f"
+			content, err = ioutil.ReadFile(filePath)
+			if err != nil {
+				return "", err
+			}
+			if opts.Content != nil {
+				content = opts.Content(rel, content)
+			}
+		default:
+			return "", fmt.Errorf("Unable to digest %s: unsupported file type", filePath)
+		}
+
+		// Only the executable bit is hashed since git does not keep any other
+		// permissions and the rest depends on the umask of whoever checked out
+		// the template
+		mode := 0644
+		if info.Mode()&0111 != 0 {
+			mode = 0755
+		}
+
+		fmt.Fprintf(hash, "%s %o %s\x00%d\x00", kind, mode, rel, len(content))
+		hash.Write(content)
+	}
+
+	return fmt.Sprintf("%s%x", digestV2Prefix, hash.Sum(nil)), nil
+}
+
+// directoryDigestV1 returns a legacy sha256 digest of all file contents in a
+// directory. It is kept to be able to verify digests in existing rig files
+func directoryDigestV1(dir string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if !info.IsDir() {
 			bytes, err := ioutil.ReadFile(path)
 			if err != nil {
@@ -64,10 +195,33 @@ func DirectoryDigest(path string) (string, error) {
 	})
 
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
-	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+	return fmt.Sprintf("%s%x", digestV1Prefix, hash.Sum(nil)), nil
+}
+
+// VerifyDirectoryDigest checks a directory against a digest. The current digest
+// of the directory is returned in the same format as the digest it was checked
+// against
+func VerifyDirectoryDigest(dir string, digest string) (string, bool, error) {
+	var current string
+	var err error
+
+	switch {
+	case strings.HasPrefix(digest, digestV2Prefix):
+		current, err = DirectoryDigest(dir)
+	case strings.HasPrefix(digest, digestV1Prefix):
+		current, err = directoryDigestV1(dir)
+	default:
+		return "", false, fmt.Errorf("Unsupported digest format: %s", digest)
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return current, current == digest, nil
 }
 
 // UnmarshalYaml reads a path and tries to unmarshal it to yaml
@@ -92,20 +246,26 @@ func UnmarshalYaml(path string) (map[string]interface{}, error) {
 	return m, nil
 }
 
-// ReadFiles reads all files in a directory and return them as a string array
-func ReadFiles(dirOrFilePath string) ([]string, error) {
-	filePaths := []string{dirOrFilePath}
-
+// ListFiles returns the path of a file or the paths of all files in a
+// directory
+func ListFiles(dirOrFilePath string) ([]string, error) {
 	fi, err := os.Stat(dirOrFilePath)
 	if err != nil {
 		return nil, err
 	}
 
 	if fi.Mode().IsDir() {
-		filePaths, err = filepath.Glob(path.Join(dirOrFilePath, "*"))
-		if err != nil {
-			return nil, err
-		}
+		return filepath.Glob(path.Join(dirOrFilePath, "*"))
+	}
+
+	return []string{dirOrFilePath}, nil
+}
+
+// ReadFiles reads all files in a directory and return them as a string array
+func ReadFiles(dirOrFilePath string) ([]string, error) {
+	filePaths, err := ListFiles(dirOrFilePath)
+	if err != nil {
+		return nil, err
 	}
 
 	var contents []string
@@ -121,3 +281,30 @@ func ReadFiles(dirOrFilePath string) ([]string, error) {
 
 	return contents, nil
 }
+
+// CopyDir recursively copies a directory
+func CopyDir(src string, dst string) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode()|0700)
+		}
+
+		bytes, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, bytes, info.Mode())
+	})
+}
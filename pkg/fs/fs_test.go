@@ -0,0 +1,179 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// digestDir writes a template dir with two files and returns it
+func digestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rig-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(path.Join(dir, "config"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"service.yaml":    "kind: Service\n",
+		"config/app.yaml": "kind: ConfigMap\n",
+	} {
+		err = ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestDirectoryDigestChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(dir string) error
+	}{
+		{
+			name: "content",
+			change: func(dir string) error {
+				return ioutil.WriteFile(path.Join(dir, "service.yaml"), []byte("kind: Deployment\n"), 0644)
+			},
+		},
+		{
+			name: "rename",
+			change: func(dir string) error {
+				return os.Rename(path.Join(dir, "service.yaml"), path.Join(dir, "svc.yaml"))
+			},
+		},
+		{
+			name: "move",
+			change: func(dir string) error {
+				return os.Rename(path.Join(dir, "config", "app.yaml"), path.Join(dir, "app.yaml"))
+			},
+		},
+		{
+			name: "mode",
+			change: func(dir string) error {
+				return os.Chmod(path.Join(dir, "service.yaml"), 0755)
+			},
+		},
+		{
+			name: "empty dir",
+			change: func(dir string) error {
+				return os.Mkdir(path.Join(dir, "empty"), 0755)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := digestDir(t)
+			defer os.RemoveAll(dir)
+
+			before, err := DirectoryDigest(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(before, digestV2Prefix) {
+				t.Fatalf("Expected a %s digest, got %s", digestV2Prefix, before)
+			}
+
+			err = test.change(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			after, err := DirectoryDigest(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if before == after {
+				t.Errorf("Expected the digest to change, got %s", after)
+			}
+
+			_, ok, err := VerifyDirectoryDigest(dir, before)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok {
+				t.Errorf("Expected %s to no longer verify", before)
+			}
+		})
+	}
+}
+
+func TestDirectoryDigestStable(t *testing.T) {
+	dir := digestDir(t)
+	defer os.RemoveAll(dir)
+
+	digest, err := DirectoryDigest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the executable bit is hashed
+	err = os.Chmod(path.Join(dir, "service.yaml"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, ok, err := VerifyDirectoryDigest(dir, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Errorf("Expected %s to verify, got %s", digest, current)
+	}
+}
+
+func TestVerifyDirectoryDigestV1(t *testing.T) {
+	dir := digestDir(t)
+	defer os.RemoveAll(dir)
+
+	// Legacy digests hash the file contents in walk order
+	digest := fmt.Sprintf("%s%x", digestV1Prefix, sha256.Sum256([]byte("kind: ConfigMap\nkind: Service\n")))
+
+	current, ok, err := VerifyDirectoryDigest(dir, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Errorf("Expected %s to verify, got %s", digest, current)
+	}
+
+	err = ioutil.WriteFile(path.Join(dir, "service.yaml"), []byte("kind: Deployment\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err = VerifyDirectoryDigest(dir, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Errorf("Expected %s to no longer verify", digest)
+	}
+}
+
+func TestVerifyDirectoryDigestUnsupported(t *testing.T) {
+	dir := digestDir(t)
+	defer os.RemoveAll(dir)
+
+	_, _, err := VerifyDirectoryDigest(dir, "md5:abc")
+	if err == nil || !strings.Contains(err.Error(), "Unsupported digest format") {
+		t.Errorf("Expected an unsupported digest format error, got %v", err)
+	}
+}
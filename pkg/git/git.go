@@ -1,50 +1,227 @@
 package git
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gonstr/rig/pkg/fs"
 )
 
-// Clone clones an url in to dir
-func Clone(dir string, url string) error {
-	cmd := exec.Command("git", "clone", url)
+// Credentials are used to authenticate against git remotes
+type Credentials struct {
+	// Username and Token are sent as basic auth to https remotes
+	Username string
+	Token    string
+	// SSHKey is the path to a private key used for ssh remotes
+	SSHKey string
+}
+
+// AuthError is returned when a git remote refuses our credentials
+type AuthError struct {
+	URL    string
+	Output string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Authentication failed for %s\n%s", e.URL, e.Output)
+}
+
+var authFailed = regexp.MustCompile(`(?i)authentication failed|could not read username|terminal prompts disabled|permission denied \(publickey|access denied|repository not found|returned error: 40[13]`)
+
+// env returns environment variables that make git use the credentials. Config
+// is passed through the environment to keep tokens out of process listings and
+// out of the remote url stored in the repository. The config is appended to
+// any GIT_CONFIG_COUNT entries already set in environ
+func (c *Credentials) env(url string, environ []string) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	var env []string
+
+	if c.Token != "" {
+		err := checkConfigEnv()
+		if err != nil {
+			return nil, err
+		}
+
+		count := 0
+		for _, e := range environ {
+			if strings.HasPrefix(e, "GIT_CONFIG_COUNT=") {
+				count, err = strconv.Atoi(strings.TrimPrefix(e, "GIT_CONFIG_COUNT="))
+				if err != nil || count < 0 {
+					return nil, fmt.Errorf("GIT_CONFIG_COUNT is invalid: %s", e)
+				}
+			}
+		}
+
+		auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", c.Username, c.Token)))
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", count+1),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s.extraHeader", count, url),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", count, auth),
+		)
+	}
+
+	if c.SSHKey != "" {
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes", shellQuote(c.SSHKey)))
+	}
+
+	return env, nil
+}
+
+var (
+	gitVersionOnce sync.Once
+	gitVersion     string
+	gitVersionErr  error
+)
+
+var gitVersionRegexp = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// checkConfigEnv returns an error if the installed git is older than 2.31.
+// Older versions ignore GIT_CONFIG_COUNT and would silently fetch without
+// the token
+func checkConfigEnv() error {
+	gitVersionOnce.Do(func() {
+		var out []byte
+		out, gitVersionErr = exec.Command("git", "version").Output()
+		gitVersion = strings.TrimSpace(string(out))
+	})
+
+	if gitVersionErr != nil {
+		return fmt.Errorf("Unable to get the git version: %s", gitVersionErr)
+	}
+
+	if !configEnvSupported(gitVersion) {
+		return fmt.Errorf("Token authentication requires git 2.31 or later, found %s", gitVersion)
+	}
+
+	return nil
+}
+
+// configEnvSupported returns true if a git version supports GIT_CONFIG_COUNT
+func configEnvSupported(version string) bool {
+	match := gitVersionRegexp.FindStringSubmatch(version)
+	if match == nil {
+		return false
+	}
+
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+
+	return major > 2 || major == 2 && minor >= 31
+}
+
+// shellQuote quotes a string for sh. Git runs GIT_SSH_COMMAND with sh
+func shellQuote(str string) string {
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
+// run runs a git command in dir. Credentials are only needed for commands
+// talking to the remote at url. The command is killed if ctx is done
+func run(ctx context.Context, dir string, url string, creds *Credentials, args ...string) error {
+	env, err := creds.env(url, os.Environ())
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, env...)
 	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
+		if url != "" && authFailed.Match(out) {
+			return &AuthError{URL: url, Output: strings.TrimSpace(string(out))}
+		}
 		return errors.New(string(out))
 	}
 
 	return nil
 }
 
-// Clean cleans a directory
-func Clean(dir string) error {
-	cmd := exec.Command("git", "fetch", "--all")
-	cmd.Dir = dir
-	out, err := cmd.CombinedOutput()
+// fetchedRefsPrefix is where refs fetched by FetchRef are stored
+const fetchedRefsPrefix = "refs/rig/"
+
+// Init initializes an empty bare repo in repoDir with url as origin. The repo
+// is set up as a partial clone so file contents are only fetched when they are
+// checked out
+func Init(ctx context.Context, repoDir string, url string) error {
+	err := fs.EnsureDir(path.Dir(repoDir))
 	if err != nil {
-		return errors.New(string(out))
+		return err
 	}
 
-	cmd = exec.Command("git", "checkout", "master")
-	cmd.Dir = dir
-	out, err = cmd.CombinedOutput()
+	err = run(ctx, path.Dir(repoDir), "", nil, "init", "-q", "--bare", repoDir)
 	if err != nil {
-		return errors.New(string(out))
+		return err
 	}
 
-	cmd = exec.Command("git", "clean", "-d", "-f")
-	cmd.Dir = dir
-	out, err = cmd.CombinedOutput()
+	err = run(ctx, repoDir, "", nil, "remote", "add", "origin", url)
 	if err != nil {
-		return errors.New(string(out))
+		return err
 	}
 
-	cmd = exec.Command("git", "pull", "-q", "origin", "master")
-	cmd.Dir = dir
+	err = run(ctx, repoDir, "", nil, "config", "remote.origin.promisor", "true")
+	if err != nil {
+		return err
+	}
+
+	return run(ctx, repoDir, "", nil, "config", "remote.origin.partialclonefilter", "blob:none")
+}
+
+// FetchRef fetches the latest commit of a single branch, tag or commit
+func FetchRef(ctx context.Context, repoDir string, url string, ref string, creds *Credentials) error {
+	refspec := fmt.Sprintf("+%s:%s%s", ref, fetchedRefsPrefix, ref)
+
+	return run(ctx, repoDir, url, creds, "fetch", "-q", "--depth", "1", "--no-tags", "origin", refspec)
+}
+
+// FetchAll fetches the full history of all branches and tags. Refs fetched
+// by FetchRef are deleted so they can not shadow the refs fetched now
+func FetchAll(ctx context.Context, repoDir string, url string, creds *Credentials) error {
+	args := []string{"fetch", "-q", "--tags", "origin", "+refs/heads/*:refs/remotes/origin/*"}
+
+	if isShallow(repoDir) {
+		args = append(args, "--unshallow")
+	}
+
+	err := run(ctx, repoDir, url, creds, args...)
+	if err != nil {
+		return err
+	}
+
+	return deleteFetchedRefs(repoDir)
+}
+
+// deleteFetchedRefs deletes all refs fetched by FetchRef
+func deleteFetchedRefs(repoDir string) error {
+	cmd := exec.Command("git", "for-each-ref", "--format=delete %(refname)", fetchedRefsPrefix)
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	cmd = exec.Command("git", "update-ref", "--stdin")
+	cmd.Dir = repoDir
+	cmd.Stdin = strings.NewReader(string(out))
 	out, err = cmd.CombinedOutput()
 	if err != nil {
 		return errors.New(string(out))
@@ -53,39 +230,99 @@ func Clean(dir string) error {
 	return nil
 }
 
-// Checkout does a git checkout of a local repo/folder to a target directory
-func Checkout(repoDir string, targetDir string, ref string, path string) error {
+// Checkout does a git checkout of a local repo/folder to a target directory.
+// Only the files in path are checked out. File contents missing in partial
+// clones are fetched from url
+func Checkout(ctx context.Context, repoDir string, targetDir string, ref string, path string, url string, creds *Credentials) error {
 	if path == "" {
 		path = "."
 	}
 
-	cmd := exec.Command("git", fmt.Sprintf("--work-tree=%s", targetDir), "checkout", ref, "--", path)
-	cmd.Dir = repoDir
-	out, err := cmd.CombinedOutput()
+	commit, err := ResolveRef(repoDir, ref)
 	if err != nil {
-		return errors.New(string(out))
+		return err
 	}
-	return nil
+
+	return run(ctx, repoDir, url, creds, fmt.Sprintf("--work-tree=%s", targetDir), "checkout", commit, "--", path)
 }
 
-// Sync either clones or cleans a dir depending on if it exists or not
-func Sync(ownerDir string, repoDir string, gitURL string) error {
+var commitSHA = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// IsCommitSHA returns true if ref is a full commit sha
+func IsCommitSHA(ref string) bool {
+	return commitSHA.MatchString(ref)
+}
+
+// ResolveRef resolves a branch, tag or commit to a commit sha. Refs fetched by
+// FetchRef and remote branches are preferred over local refs since they are
+// the ones updated by fetch
+func ResolveRef(repoDir string, ref string) (string, error) {
+	for _, candidate := range []string{fetchedRefsPrefix + ref, "refs/remotes/origin/" + ref, ref} {
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", candidate+"^{commit}")
+		cmd.Dir = repoDir
+		out, err := cmd.Output()
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+
+	return "", fmt.Errorf("Unable to find ref %s", ref)
+}
+
+// Sync makes sure the repo in repoDir contains the latest commit of ref. New
+// repos are initialized as partial clones and only ref is fetched. If the
+// remote can not fetch a single ref, or if full is true, all branches and tags
+// are fetched instead
+func Sync(ctx context.Context, repoDir string, url string, ref string, creds *Credentials, full bool) error {
 	if fs.PathExists(repoDir) {
-		err := Clean(repoDir)
+		err := run(ctx, repoDir, "", nil, "remote", "set-url", "origin", url)
 		if err != nil {
 			return err
 		}
 	} else {
-		err := fs.EnsureDir(ownerDir)
+		err := Init(ctx, repoDir, url)
 		if err != nil {
+			os.RemoveAll(repoDir)
 			return err
 		}
+	}
 
-		err = Clone(ownerDir, gitURL)
-		if err != nil {
+	if !full {
+		err := FetchRef(ctx, repoDir, url, ref, creds)
+		if _, ok := err.(*AuthError); ok || err == nil {
 			return err
 		}
 	}
 
-	return nil
+	err := FetchAll(ctx, repoDir, url, creds)
+	if err != nil {
+		return err
+	}
+
+	_, err = ResolveRef(repoDir, ref)
+
+	return err
+}
+
+// Tag returns the raw tag object of an annotated tag
+func Tag(repoDir string, ref string) ([]byte, error) {
+	for _, candidate := range []string{fetchedRefsPrefix + ref, ref} {
+		cmd := exec.Command("git", "cat-file", "tag", candidate)
+		cmd.Dir = repoDir
+		out, err := cmd.Output()
+		if err == nil {
+			return out, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not an annotated tag", ref)
+}
+
+// isShallow returns true if a repo has incomplete history
+func isShallow(repoDir string) bool {
+	cmd := exec.Command("git", "rev-parse", "--is-shallow-repository")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+
+	return err == nil && strings.TrimSpace(string(out)) == "true"
 }
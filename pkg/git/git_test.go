@@ -0,0 +1,221 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// upstream creates a git repository with a commit on master
+func upstream(t testing.TB, dir string) string {
+	repoDir := path.Join(dir, "upstream")
+
+	err := os.MkdirAll(repoDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gitCmd(t, repoDir, "init", "-q")
+	gitCmd(t, repoDir, "checkout", "-q", "-b", "master")
+	commit(t, repoDir, "app/templates/a.yaml", "a: 1\n")
+
+	return repoDir
+}
+
+func commit(t testing.TB, repoDir string, name string, content string) string {
+	write(t, path.Join(repoDir, name), content)
+
+	gitCmd(t, repoDir, "add", "-A")
+	gitCmd(t, repoDir, "commit", "-q", "-m", name)
+
+	return gitCmd(t, repoDir, "rev-parse", "HEAD")
+}
+
+func write(t testing.TB, filePath string, content string) {
+	err := os.MkdirAll(path.Dir(filePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gitCmd(t testing.TB, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=rig", "-c", "user.email=rig@example.com"}, args...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s\n%s", args, err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func TestSyncFullAfterShallow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	url := upstream(t, dir)
+	repoDir := path.Join(dir, "cache", "upstream")
+
+	err = Sync(context.Background(), repoDir, url, "master", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := commit(t, url, "app/templates/a.yaml", "a: 2\n")
+
+	err = Sync(context.Background(), repoDir, url, "master", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := ResolveRef(repoDir, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved != head {
+		t.Errorf("master resolved to %s after a full fetch, expected %s", resolved, head)
+	}
+}
+
+func TestSSHKeyQuoted(t *testing.T) {
+	for _, key := range []string{"/keys/id_ed25519", "/keys/it's key", "/keys/$(touch pwned)'; touch pwned; '"} {
+		creds := &Credentials{SSHKey: key}
+
+		environ, err := creds.env("ssh://example.com/repo.git", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var command string
+		for _, env := range environ {
+			if strings.HasPrefix(env, "GIT_SSH_COMMAND=") {
+				command = strings.TrimPrefix(env, "GIT_SSH_COMMAND=")
+			}
+		}
+
+		// Print the arguments sh passes to ssh instead of running it
+		out, err := exec.Command("sh", "-c", "printf '%s\\n' "+strings.TrimPrefix(command, "ssh ")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+
+		args := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(args) < 2 || args[0] != "-i" || args[1] != key {
+			t.Errorf("expected ssh to get key %s, got %v", key, args)
+		}
+	}
+}
+
+func TestTokenConfigAppended(t *testing.T) {
+	creds := &Credentials{Username: "rig", Token: "token"}
+
+	tests := []struct {
+		environ []string
+		index   int
+	}{
+		{nil, 0},
+		{[]string{"GIT_CONFIG_COUNT=2", "GIT_CONFIG_KEY_0=a.b", "GIT_CONFIG_KEY_1=c.d"}, 2},
+	}
+
+	for _, test := range tests {
+		env, err := creds.env("https://example.com/repo.git", test.environ)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", test.index+1),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=http.https://example.com/repo.git.extraHeader", test.index),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic cmlnOnRva2Vu", test.index),
+		}
+
+		if !reflect.DeepEqual(env, expected) {
+			t.Errorf("expected %v, got %v", expected, env)
+		}
+	}
+
+	_, err := creds.env("https://example.com/repo.git", []string{"GIT_CONFIG_COUNT=x"})
+	if err == nil {
+		t.Error("expected an invalid GIT_CONFIG_COUNT to fail")
+	}
+}
+
+func TestConfigEnvSupported(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"git version 2.30.2":                   false,
+		"git version 2.31.0":                   true,
+		"git version 2.39.5":                   true,
+		"git version 2.24.3 (Apple Git-128)":   false,
+		"git version 2.37.1 (Apple Git-137.1)": true,
+		"git version 3.0.0":                    true,
+		"git version 1.8.3.1":                  false,
+		"not git":                              false,
+	} {
+		if actual := configEnvSupported(version); actual != expected {
+			t.Errorf("%s: expected %t, got %t", version, expected, actual)
+		}
+	}
+}
+
+// BenchmarkSync compares syncing a single ref with fetching all history from a
+// repository with many commits and files
+func BenchmarkSync(b *testing.B) {
+	dir, err := ioutil.TempDir("", "rig-git")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	url := upstream(b, dir)
+
+	for i := 0; i < 200; i++ {
+		for j := 0; j < 10; j++ {
+			name := fmt.Sprintf("app%d/templates/file%d.yaml", j, i)
+			write(b, path.Join(url, name), strings.Repeat(name+"\n", 100))
+		}
+		commit(b, url, fmt.Sprintf("commits/%d", i), "")
+	}
+
+	for _, full := range []bool{false, true} {
+		b.Run(fmt.Sprintf("full=%t", full), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				repoDir := path.Join(dir, fmt.Sprintf("cache-%t-%d", full, i))
+
+				err := Sync(context.Background(), repoDir, "file://"+url, "master", nil, full)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				targetDir := repoDir + "-checkout"
+
+				err = os.MkdirAll(targetDir, 0755)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				err = Checkout(context.Background(), repoDir, targetDir, "master", "app0", "file://"+url, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
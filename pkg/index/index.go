@@ -0,0 +1,349 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/ghodss/yaml"
+
+	"github.com/gonstr/rig/pkg/config"
+	rigcontext "github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/fetch"
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+// FileName is the name of index files
+const FileName = "index.yaml"
+
+// Entry is a template version in an index
+type Entry struct {
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	URL         string   `json:"url"`
+}
+
+// Index lists template versions by template name
+type Index struct {
+	Templates map[string][]Entry `json:"templates"`
+}
+
+// Result is a template version found in a registered index
+type Result struct {
+	Repository string
+	Name       string
+	Entry
+}
+
+// templateRef matches template references such as myrepo/simple-app@1.0.0
+var templateRef = regexp.MustCompile(`^([\w.-]+)/([\w.-]+)(?:@(.+))?$`)
+
+// Load loads an index. The index url can either be a local path or a template
+// url pointing to a git repository. Urls can point to the index file itself or
+// to the dir containing it. Fetching is stopped if ctx is done
+func Load(ctx context.Context, url string, offline bool) (*Index, error) {
+	if fs.PathExists(url) {
+		return loadFile(url, true)
+	}
+
+	tmpl, err := rigcontext.FromURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath, cleanup, err := fetch.Template(ctx, tmpl, offline)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	return loadFile(indexPath, false)
+}
+
+// loadFile loads an index file or the index file in a dir. Relative entry urls
+// of local indexes are resolved against the dir of the index file. Fetched
+// indexes are checked out to a temp dir so they can not have relative urls
+func loadFile(indexPath string, local bool) (*Index, error) {
+	info, err := os.Stat(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		indexPath = path.Join(indexPath, FileName)
+	}
+
+	bytes, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var i Index
+
+	err = yaml.Unmarshal(bytes, &i)
+	if err != nil {
+		return nil, fmt.Errorf("%s is malformed: %s", indexPath, err)
+	}
+
+	indexDir, err := filepath.Abs(filepath.Dir(indexPath))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, entries := range i.Templates {
+		for j, entry := range entries {
+			if _, err := semver.NewVersion(entry.Version); err != nil {
+				return nil, fmt.Errorf("%s is malformed: invalid version '%s' of %s", indexPath, entry.Version, name)
+			}
+
+			if entry.URL == "" {
+				return nil, fmt.Errorf("%s is malformed: version %s of %s has no url", indexPath, entry.Version, name)
+			}
+
+			if isRelative(entry.URL) {
+				if !local {
+					return nil, fmt.Errorf("%s is malformed: version %s of %s has a relative url. Relative urls are only supported in local indexes", path.Base(indexPath), entry.Version, name)
+				}
+
+				entries[j].URL = resolve(indexDir, entry.URL)
+			}
+		}
+	}
+
+	return &i, nil
+}
+
+// isRelative returns true if an entry url is a path relative to the index
+func isRelative(url string) bool {
+	return strings.HasPrefix(url, "./") || strings.HasPrefix(url, "../")
+}
+
+// resolve joins a relative entry url to the dir of the index. The template
+// path after a double slash and the ref are kept as is
+func resolve(indexDir string, url string) string {
+	rest := ""
+
+	if i := strings.Index(url, "//"); i >= 0 {
+		url, rest = url[:i], url[i:]
+	} else if i := strings.Index(url, "#"); i >= 0 {
+		url, rest = url[:i], url[i:]
+	}
+
+	return filepath.ToSlash(filepath.Join(indexDir, url)) + rest
+}
+
+// AddRepository registers an index. The index is loaded to make sure it is
+// valid. Local index paths are stored as absolute paths
+func AddRepository(ctx context.Context, name string, url string, offline bool) error {
+	if !regexp.MustCompile(`^[\w.-]+$`).MatchString(name) {
+		return fmt.Errorf("Invalid repository name '%s': only letters, digits, dots, dashes and underscores are allowed", name)
+	}
+
+	if fs.PathExists(url) {
+		abs, err := filepath.Abs(url)
+		if err != nil {
+			return err
+		}
+		url = abs
+	}
+
+	_, err := Load(ctx, url, offline)
+	if err != nil {
+		return err
+	}
+
+	repos, err := config.LoadRepositories()
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos.Repositories {
+		if repo.Name == name {
+			return fmt.Errorf("Repository %s already exists", name)
+		}
+	}
+
+	repos.Repositories = append(repos.Repositories, config.Repository{Name: name, URL: url})
+
+	return config.SaveRepositories(repos)
+}
+
+// RemoveRepository unregisters an index
+func RemoveRepository(name string) error {
+	repos, err := config.LoadRepositories()
+	if err != nil {
+		return err
+	}
+
+	for i, repo := range repos.Repositories {
+		if repo.Name == name {
+			repos.Repositories = append(repos.Repositories[:i], repos.Repositories[i+1:]...)
+			return config.SaveRepositories(repos)
+		}
+	}
+
+	return fmt.Errorf("Repository %s does not exist", name)
+}
+
+// Search returns the latest version of all templates in the registered indexes
+// whose name, description or keywords contain term. Indexes that can not be
+// loaded are skipped and their errors are returned with the results. An error
+// is only returned if no index could be loaded
+func Search(ctx context.Context, term string, offline bool) ([]Result, []error, error) {
+	repos, err := config.LoadRepositories()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	term = strings.ToLower(term)
+
+	var results []Result
+	var skipped []error
+
+	for _, repo := range repos.Repositories {
+		i, err := Load(ctx, repo.URL, offline)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("Unable to load repository %s: %s", repo.Name, err))
+			continue
+		}
+
+		for name, entries := range i.Templates {
+			entry, err := latest(entries, "")
+			if err != nil {
+				continue
+			}
+
+			haystack := strings.ToLower(strings.Join(append([]string{name, entry.Description}, entry.Keywords...), " "))
+
+			if strings.Contains(haystack, term) {
+				results = append(results, Result{Repository: repo.Name, Name: name, Entry: *entry})
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repository != results[j].Repository {
+			return results[i].Repository < results[j].Repository
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	if len(skipped) > 0 && len(skipped) == len(repos.Repositories) {
+		return nil, nil, skipped[0]
+	}
+
+	return results, skipped, nil
+}
+
+// IsRef returns true if str is a reference to a template in a registered
+// index, e.g. myrepo/simple-app or myrepo/simple-app@1.0.0
+func IsRef(str string) bool {
+	m := templateRef.FindStringSubmatch(str)
+	if m == nil || fs.PathExists(str) {
+		return false
+	}
+
+	_, err := repository(m[1])
+
+	return err == nil
+}
+
+// Resolve resolves a template reference through the registered indexes. The
+// version can be an exact version or a semver constraint. The latest version is
+// used if no version is supplied. Fetching the index is stopped if ctx is done
+func Resolve(ctx context.Context, ref string, offline bool) (rigcontext.Context, error) {
+	m := templateRef.FindStringSubmatch(ref)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid template reference '%s': expected <repository>/<template>[@<version>]", ref)
+	}
+
+	repo, err := repository(m[1])
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := Load(ctx, repo.URL, offline)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load repository %s: %s", repo.Name, err)
+	}
+
+	entries, ok := i.Templates[m[2]]
+	if !ok {
+		return nil, fmt.Errorf("Template %s not found in repository %s", m[2], repo.Name)
+	}
+
+	entry, err := latest(entries, m[3])
+	if err != nil {
+		return nil, fmt.Errorf("Template %s in repository %s: %s", m[2], repo.Name, err)
+	}
+
+	return rigcontext.FromURL(entry.URL)
+}
+
+func repository(name string) (*config.Repository, error) {
+	repos, err := config.LoadRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repo := range repos.Repositories {
+		if repo.Name == name {
+			return &repo, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Repository %s does not exist. Add it with 'rig repo add'", name)
+}
+
+// latest returns the highest version of entries matching a version constraint.
+// An empty constraint matches all versions
+func latest(entries []Entry, constraint string) (*Entry, error) {
+	var c *semver.Constraints
+
+	if constraint != "" {
+		var err error
+		c, err = semver.NewConstraint(constraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version '%s'", constraint)
+		}
+	}
+
+	var best *Entry
+	var bestVersion *semver.Version
+
+	for i := range entries {
+		v, err := semver.NewVersion(entries[i].Version)
+		if err != nil {
+			continue
+		}
+
+		if c != nil && !c.Check(v) {
+			continue
+		}
+
+		if best == nil || v.GreaterThan(bestVersion) {
+			best = &entries[i]
+			bestVersion = v
+		}
+	}
+
+	if best == nil {
+		if constraint == "" {
+			return nil, fmt.Errorf("no versions")
+		}
+		return nil, fmt.Errorf("no version matches %s", constraint)
+	}
+
+	return best, nil
+}
@@ -0,0 +1,166 @@
+package index
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/gonstr/rig/pkg/config"
+)
+
+// setup isolates the rig config and cache in a temp dir and returns it
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rig-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+	os.Setenv("RIG_CACHE_DIR", path.Join(dir, "cache"))
+
+	return dir
+}
+
+func write(t *testing.T, filePath string, content string) {
+	err := os.MkdirAll(path.Dir(filePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gitRepo commits an index file to a new git repository and returns its path
+func gitRepo(t *testing.T, dir string, content string) string {
+	repoDir := path.Join(dir, "index.git")
+
+	write(t, path.Join(repoDir, FileName), content)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", "master"},
+		{"add", "-A"},
+		{"commit", "-q", "-m", "index"},
+	} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=rig", "-c", "user.email=rig@example.com"}, args...)...)
+		cmd.Dir = repoDir
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+
+	return repoDir
+}
+
+const relativeIndex = `templates:
+  simple-app:
+    - version: 1.0.0
+      url: ./dist/simple-app-1.0.0.tgz
+    - version: 1.1.0
+      url: ../templates.git//simple-app#v1.1.0
+    - version: 1.2.0
+      url: https://github.com/gonstr/rig-templates/simple-app#simple-app/v1.2.0
+`
+
+func TestLoadRelativeURLs(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	write(t, path.Join(dir, "indexes", FileName), relativeIndex)
+
+	i, err := Load(context.Background(), path.Join(dir, "indexes", FileName), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		path.Join(dir, "indexes", "dist", "simple-app-1.0.0.tgz"),
+		path.Join(dir, "templates.git") + "//simple-app#v1.1.0",
+		"https://github.com/gonstr/rig-templates/simple-app#simple-app/v1.2.0",
+	}
+
+	for j, entry := range i.Templates["simple-app"] {
+		if entry.URL != expected[j] {
+			t.Errorf("Expected url %s, got %s", expected[j], entry.URL)
+		}
+	}
+}
+
+func TestLoadFetchedRelativeURL(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	repoDir := gitRepo(t, dir, relativeIndex)
+
+	_, err := Load(context.Background(), "file://"+repoDir+"//"+FileName+"#master", false)
+	if err == nil || !strings.Contains(err.Error(), "Relative urls are only supported in local indexes") {
+		t.Errorf("Expected relative urls in a fetched index to be refused, got %v", err)
+	}
+}
+
+func TestLoadCanceled(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	repoDir := gitRepo(t, dir, "templates: {}\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Load(ctx, "file://"+repoDir+"//"+FileName+"#master", false)
+	if err != context.Canceled {
+		t.Errorf("Expected loading to stop when ctx is done, got %v", err)
+	}
+}
+
+func TestSearchSkipsUnreachable(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	write(t, path.Join(dir, "indexes", FileName), relativeIndex)
+
+	missing := config.Repository{Name: "missing", URL: "file://" + path.Join(dir, "missing.git") + "//" + FileName + "#master"}
+
+	err := config.SaveRepositories(&config.Repositories{Repositories: []config.Repository{
+		missing,
+		{Name: "local", URL: path.Join(dir, "indexes")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, skipped, err := Search(context.Background(), "simple", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Repository != "local" || results[0].Version != "1.2.0" {
+		t.Errorf("Expected simple-app 1.2.0 from local, got %v", results)
+	}
+
+	if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "Unable to load repository missing") {
+		t.Errorf("Expected repository missing to be skipped, got %v", skipped)
+	}
+
+	err = config.SaveRepositories(&config.Repositories{Repositories: []config.Repository{missing}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = Search(context.Background(), "simple", false)
+	if err == nil || !strings.Contains(err.Error(), "Unable to load repository missing") {
+		t.Errorf("Expected an error when no repository can be loaded, got %v", err)
+	}
+}
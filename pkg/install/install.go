@@ -1,107 +1,215 @@
 package install
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strings"
 
-	"github.com/gonstr/rig/pkg/context"
+	"github.com/ghodss/yaml"
+
+	rigcontext "github.com/gonstr/rig/pkg/context"
 	"github.com/gonstr/rig/pkg/engine"
+	"github.com/gonstr/rig/pkg/fetch"
 	"github.com/gonstr/rig/pkg/fs"
-	"github.com/gonstr/rig/pkg/git"
+	"github.com/gonstr/rig/pkg/metadata"
+	"github.com/gonstr/rig/pkg/sign"
+	"github.com/gonstr/rig/pkg/valueref"
 )
 
+// unsafeSchemes are value reference schemes templates can not ship in
+// values.yaml. Installed values are resolved on every build, so a template
+// could run commands or read the environment and local files outside of the
+// allowEnv sandbox
+var unsafeSchemes = map[string]bool{"exec": true, "env": true, "file": true}
+
 const rigTmpl = `template:
+{{- if .Path }}
+  path: {{ .Path }}
+{{- else }}
   url: {{ .URL }}
+{{- if .Gitref }}
   gitref: {{ .Gitref }}
+{{- end }}
+{{- end }}
   digest: {{ .Digest }}
 
 values:
   {{ .Values | indent 2 | trim }}
 `
 
-// FromURL installs a rig template from an url
-func FromURL(url string, force bool) error {
-	ctx, err := context.FromURL(url)
+// Options are options for installing templates
+type Options struct {
+	// Force overwrites an existing rig.yaml
+	Force bool
+	// Verify requires templates to be signed by a trusted key
+	Verify bool
+	// Offline installs templates from the cache without fetching
+	Offline bool
+}
+
+type rigData struct {
+	Path   string
+	URL    string
+	Gitref string
+	Digest string
+	Values string
+}
+
+// FromURL installs a rig template from an url. The template metadata is
+// returned if the template has a metadata file
+func FromURL(url string, opts Options) (*metadata.Metadata, error) {
+	tmpl, err := rigcontext.FromURL(url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	ownerDir, err := ctx.OwnerDir()
+	return FromContext(tmpl, opts)
+}
+
+// FromContext installs the remote rig template of a context. The template
+// metadata is returned if the template has a metadata file
+func FromContext(tmpl rigcontext.Context, opts Options) (*metadata.Metadata, error) {
+	tmpl, err := fetch.Pin(context.Background(), tmpl, opts.Offline)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	repoDir, err := ctx.RepoDir()
+	templateDir, cleanup, err := fetch.Template(context.Background(), tmpl, opts.Offline)
+	defer cleanup()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	gitURL, err := ctx.RepoURL()
+	fullURL, err := tmpl.URL()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = git.Sync(ownerDir, repoDir, gitURL)
-	if err != nil {
-		return err
+	if opts.Verify {
+		err = sign.Verify(tmpl, templateDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := rigData{URL: fullURL}
+
+	// Archives have no gitref. The tag of oci templates is part of the url
+	if !tmpl.Archive() {
+		data.Gitref = tmpl.Gitref()
 	}
 
-	tmpDir, err := fs.TempDir()
+	return install(templateDir, data, opts.Force)
+}
+
+// FromPath installs a local rig template. The template path is stored in
+// rig.yaml as is so it should be relative to the current directory. The
+// template metadata is returned if the template has a metadata file
+func FromPath(templatePath string, opts Options) (*metadata.Metadata, error) {
+	wd, err := os.Getwd()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer os.RemoveAll(tmpDir)
+	templateDir := path.Join(wd, templatePath)
+
+	if !fs.PathExists(path.Join(templateDir, "templates")) {
+		return nil, fmt.Errorf("%s is not a template: templates dir is missing", templatePath)
+	}
+
+	if opts.Verify {
+		tmpl, err := rigcontext.FromPath(templatePath)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sign.Verify(tmpl, templateDir)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	err = git.Checkout(repoDir, tmpDir, ctx.Gitref(), ctx.Path())
+	return install(templateDir, rigData{Path: templatePath}, opts.Force)
+}
+
+// install writes rig.yaml for the template in templateDir
+func install(templateDir string, data rigData, force bool) (*metadata.Metadata, error) {
+	meta, err := metadata.FromDir(templateDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	wd, err := os.Getwd()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if fs.PathExists(path.Join(wd, "ctx.yaml")) && !force {
-		return errors.New("ctx.yaml already exists. FORCE install with --force or -f")
+	if fs.PathExists(path.Join(wd, "rig.yaml")) && !force {
+		return nil, errors.New("rig.yaml already exists. FORCE install with --force or -f")
 	}
 
-	values, err := ioutil.ReadFile(path.Join(tmpDir, ctx.Path(), "values.yaml"))
+	values, err := ioutil.ReadFile(path.Join(templateDir, "values.yaml"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	digest, err := fs.DirectoryDigest(path.Join(tmpDir, ctx.Path(), "templates"))
-
-	fullURL, err := ctx.URL()
+	err = checkValueRefs(values)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	tmplData := struct {
-		URL    string
-		Gitref string
-		Digest string
-		Values string
-	}{
-		URL:    fullURL,
-		Gitref: ctx.Gitref(),
-		Digest: digest,
-		Values: string(values),
+	data.Values = string(values)
+
+	data.Digest, err = fs.DirectoryDigest(path.Join(templateDir, "templates"))
+	if err != nil {
+		return nil, err
 	}
 
-	bytes, err := engine.Render(rigTmpl, tmplData, false)
+	bytes, err := engine.Render(rigTmpl, data, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = ioutil.WriteFile(path.Join(wd, "rig.yaml"), bytes, 0644)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return meta, nil
+}
+
+// checkValueRefs returns an error if the template values reference an unsafe
+// scheme
+func checkValueRefs(values []byte) error {
+	var vals map[string]interface{}
+
+	err := yaml.Unmarshal(values, &vals)
+	if err != nil {
+		return fmt.Errorf("values.yaml is malformed: %s", err)
+	}
+
+	refs, err := valueref.Find(vals)
+	if err != nil {
+		return fmt.Errorf("values.yaml is malformed: %s", err)
+	}
+
+	var unsafe []string
+	for key, ref := range refs {
+		if unsafeSchemes[ref.Scheme] {
+			unsafe = append(unsafe, fmt.Sprintf("%s: %s", key, ref))
+		}
+	}
+
+	if len(unsafe) == 0 {
+		return nil
+	}
+
+	sort.Strings(unsafe)
+
+	return fmt.Errorf("Refusing to install template with %senv://, %sexec:// or %sfile:// values. Set them in rig.yaml after installing:\n%s", valueref.Prefix, valueref.Prefix, valueref.Prefix, strings.Join(unsafe, "\n"))
 }
@@ -0,0 +1,27 @@
+package install
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckValueRefs(t *testing.T) {
+	for values, safe := range map[string]bool{
+		"host: example.com":                                true,
+		"password: ref+vault://secret/db#password":         true,
+		"password: ref+env://DB_PASSWORD":                  false,
+		"password: ref+exec://cat /etc/passwd":             false,
+		"credentials: ref+file:///home/u/.aws/credentials": false,
+		"db:\n  password: ref+file://../../secret":         false,
+		"hosts:\n  - ref+file://hosts.txt":                 false,
+	} {
+		err := checkValueRefs([]byte(values))
+		if (err == nil) != safe {
+			t.Errorf("expected '%s' to be safe %t, got %v", values, safe, err)
+		}
+
+		if err != nil && !strings.Contains(err.Error(), "Set them in rig.yaml after installing") {
+			t.Errorf("expected '%s' to be refused, got %s", values, err)
+		}
+	}
+}
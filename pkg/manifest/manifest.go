@@ -0,0 +1,102 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+var separator = regexp.MustCompile(`(?m)^---[ \t]*(#.*)?$`)
+var containsNonWhitespace = regexp.MustCompile(`\S+`)
+
+// Split splits a multi document yaml string into documents. Documents that
+// only contain whitespace are dropped
+func Split(str string) []string {
+	var docs []string
+
+	for _, doc := range separator.Split(str, -1) {
+		doc = strings.TrimSpace(doc)
+
+		if containsNonWhitespace.MatchString(doc) {
+			docs = append(docs, doc)
+		}
+	}
+
+	return docs
+}
+
+// Join joins documents to a multi document yaml string
+func Join(docs []string) string {
+	return strings.Join(docs, "\n---\n")
+}
+
+// Parse parses all objects of a multi document yaml string. Documents that
+// only contain comments are dropped
+func Parse(str string) ([]map[string]interface{}, error) {
+	var objs []map[string]interface{}
+
+	for i, doc := range Split(str) {
+		var obj map[string]interface{}
+
+		err := yaml.Unmarshal([]byte(doc), &obj)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse document %d of the manifest: %s", i+1, err)
+		}
+
+		if obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs, nil
+}
+
+// String returns objects as a multi document yaml string
+func String(objs []map[string]interface{}) (string, error) {
+	docs := make([]string, len(objs))
+
+	for i, obj := range objs {
+		bytes, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+
+		docs[i] = strings.TrimSpace(string(bytes))
+	}
+
+	return Join(docs), nil
+}
+
+// Kind returns the kind of an object
+func Kind(obj map[string]interface{}) string {
+	kind, _ := obj["kind"].(string)
+	return kind
+}
+
+// Metadata returns the metadata of an object. The metadata is added to the
+// object if it is missing
+func Metadata(obj map[string]interface{}) map[string]interface{} {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+		obj["metadata"] = metadata
+	}
+
+	return metadata
+}
+
+// Name returns the name of an object
+func Name(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+// Namespace returns the namespace of an object
+func Namespace(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	return namespace
+}
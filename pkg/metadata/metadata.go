@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/Masterminds/semver"
+	"github.com/ghodss/yaml"
+
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+// FileName is the name of the metadata file in a template directory
+const FileName = "rig-template.yaml"
+
+// Maintainer is a person or team maintaining a template
+type Maintainer struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Metadata describes a rig template
+type Metadata struct {
+	Name        string       `json:"name"`
+	Version     string       `json:"version"`
+	Description string       `json:"description,omitempty"`
+	Maintainers []Maintainer `json:"maintainers,omitempty"`
+	Keywords    []string     `json:"keywords,omitempty"`
+	RigVersion  string       `json:"rigVersion,omitempty"`
+	// Digest is the digest of the template files. It is set when a template
+	// is packaged
+	Digest string `json:"digest,omitempty"`
+}
+
+// FromFile reads and validates a metadata file
+func FromFile(filePath string) (*Metadata, error) {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Metadata
+
+	err = yaml.Unmarshal(bytes, &m)
+	if err != nil {
+		return nil, fmt.Errorf("%s is malformed: %s", filePath, err)
+	}
+
+	if m.Name == "" {
+		return nil, fmt.Errorf("%s is malformed: name is required", filePath)
+	}
+
+	if _, err := semver.NewVersion(m.Version); err != nil {
+		return nil, fmt.Errorf("%s is malformed: invalid version '%s'", filePath, m.Version)
+	}
+
+	if m.RigVersion != "" {
+		if _, err := semver.NewConstraint(m.RigVersion); err != nil {
+			return nil, fmt.Errorf("%s is malformed: invalid rigVersion constraint '%s'", filePath, m.RigVersion)
+		}
+	}
+
+	return &m, nil
+}
+
+// FromDir reads the metadata file of a template directory. Templates are not
+// required to have a metadata file so nil is returned if it does not exist
+func FromDir(dir string) (*Metadata, error) {
+	filePath := path.Join(dir, FileName)
+
+	if !fs.PathExists(filePath) {
+		return nil, nil
+	}
+
+	return FromFile(filePath)
+}
+
+// CheckRigVersion returns an error if a rig version does not satisfy the
+// rigVersion constraint of the template
+func (m *Metadata) CheckRigVersion(rigVersion string) error {
+	if m.RigVersion == "" {
+		return nil
+	}
+
+	constraint, err := semver.NewConstraint(m.RigVersion)
+	if err != nil {
+		return err
+	}
+
+	v, err := semver.NewVersion(rigVersion)
+	if err != nil {
+		return err
+	}
+
+	if !constraint.Check(v) {
+		return fmt.Errorf("Template %s v%s requires rig %s but this is rig v%s", m.Name, m.Version, m.RigVersion, rigVersion)
+	}
+
+	return nil
+}
+
+// String returns a short human readable description of the template
+func (m *Metadata) String() string {
+	str := fmt.Sprintf("%s v%s", m.Name, m.Version)
+
+	if m.Description != "" {
+		str = fmt.Sprintf("%s - %s", str, m.Description)
+	}
+
+	return str
+}
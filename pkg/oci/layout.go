@@ -0,0 +1,214 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// Layout is an oci image layout dir. Pulled templates are stored in a layout
+// in the cache so they can be used offline
+type Layout struct {
+	Dir string
+}
+
+type layoutIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// CheckDigest returns an error if a digest is not a sha256 digest. Digests are
+// checked before they are used in blob paths since they are read from
+// manifests and indexes
+func CheckDigest(digest string) error {
+	if !digestRegexp.MatchString(digest) {
+		return fmt.Errorf("Invalid digest %s", digest)
+	}
+
+	return nil
+}
+
+// BlobPath returns the path of a blob in the layout. The digest must have been
+// checked with CheckDigest
+func (l Layout) BlobPath(digest string) string {
+	return path.Join(l.Dir, "blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// HasBlob returns true if the layout contains a blob
+func (l Layout) HasBlob(digest string) bool {
+	return CheckDigest(digest) == nil && fs.PathExists(l.BlobPath(digest))
+}
+
+// ReadBlob returns a blob of the layout and verifies it against its digest
+func (l Layout) ReadBlob(digest string) ([]byte, error) {
+	err := CheckDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := ioutil.ReadFile(l.BlobPath(digest))
+	if err != nil {
+		return nil, err
+	}
+
+	if Digest(blob) != digest {
+		return nil, fmt.Errorf("Cached blob %s does not match its digest", digest)
+	}
+
+	return blob, nil
+}
+
+// VerifyBlob verifies a blob of the layout against its digest without reading
+// it into memory
+func (l Layout) VerifyBlob(digest string) error {
+	err := CheckDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(l.BlobPath(digest))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+
+	if fmt.Sprintf("sha256:%x", hash.Sum(nil)) != digest {
+		return fmt.Errorf("Cached blob %s does not match its digest", digest)
+	}
+
+	return nil
+}
+
+// WriteBlob writes a blob to the layout
+func (l Layout) WriteBlob(digest string, blob []byte) error {
+	err := CheckDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	if Digest(blob) != digest {
+		return fmt.Errorf("Blob %s does not match its digest", digest)
+	}
+
+	err = l.init()
+	if err != nil {
+		return err
+	}
+
+	blobPath := l.BlobPath(digest)
+
+	err = fs.EnsureDir(path.Dir(blobPath))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := blobPath + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, blob, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, blobPath)
+}
+
+// Tag points a tag at a manifest in the layout
+func (l Layout) Tag(tag string, manifest Descriptor) error {
+	err := CheckDigest(manifest.Digest)
+	if err != nil {
+		return err
+	}
+
+	index, err := l.index()
+	if err != nil {
+		return err
+	}
+
+	manifests := []Descriptor{}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[refNameAnnotation] != tag {
+			manifests = append(manifests, desc)
+		}
+	}
+
+	manifest.Annotations = map[string]string{refNameAnnotation: tag}
+	index.Manifests = append(manifests, manifest)
+
+	bytes, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(l.Dir, "index.json"), bytes, 0644)
+}
+
+// Resolve returns the manifest digest of a tag in the layout
+func (l Layout) Resolve(tag string) (string, error) {
+	index, err := l.index()
+	if err != nil {
+		return "", err
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[refNameAnnotation] == tag {
+			return desc.Digest, CheckDigest(desc.Digest)
+		}
+	}
+
+	return "", fmt.Errorf("Tag %s not found", tag)
+}
+
+// init creates the oci-layout file of a new layout
+func (l Layout) init() error {
+	layoutPath := path.Join(l.Dir, "oci-layout")
+
+	if fs.PathExists(layoutPath) {
+		return nil
+	}
+
+	err := fs.EnsureDir(l.Dir)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(layoutPath, []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+}
+
+func (l Layout) index() (*layoutIndex, error) {
+	index := layoutIndex{SchemaVersion: 2, Manifests: []Descriptor{}}
+
+	indexPath := path.Join(l.Dir, "index.json")
+
+	if !fs.PathExists(indexPath) {
+		return &index, nil
+	}
+
+	bytes, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(bytes, &index)
+	if err != nil {
+		return nil, fmt.Errorf("%s is malformed: %s", indexPath, err)
+	}
+
+	return &index, nil
+}
@@ -0,0 +1,112 @@
+package oci
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCheckDigest(t *testing.T) {
+	for digest, valid := range map[string]bool{
+		Digest([]byte("blob")):                    true,
+		"sha256:../../x":                          false,
+		"sha256:" + strings.Repeat("a", 63) + "/": false,
+		"sha512:" + strings.Repeat("a", 64):       false,
+		"":                                        false,
+	} {
+		if err := CheckDigest(digest); (err == nil) != valid {
+			t.Errorf("expected digest %s to be valid %t, got %v", digest, valid, err)
+		}
+	}
+}
+
+func TestLayoutInvalidDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := Layout{Dir: path.Join(dir, "layout")}
+
+	outside := path.Join(dir, "x")
+
+	err = ioutil.WriteFile(outside, []byte("outside"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "sha256:../../../x"
+
+	if layout.HasBlob(digest) {
+		t.Error("expected a digest with a path to not be found")
+	}
+
+	if _, err := layout.ReadBlob(digest); err == nil {
+		t.Error("expected reading a digest with a path to fail")
+	}
+
+	if err := layout.WriteBlob(digest, []byte("blob")); err == nil {
+		t.Error("expected writing a digest with a path to fail")
+	}
+
+	err = os.MkdirAll(layout.Dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path.Join(layout.Dir, "index.json"), []byte(`{"manifests": [{"digest": "sha256:../../../x", "annotations": {"org.opencontainers.image.ref.name": "latest"}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := layout.Resolve("latest"); err == nil {
+		t.Error("expected resolving a tag to a digest with a path to fail")
+	}
+
+	manifest := Manifest{Layers: []Descriptor{{MediaType: LayerMediaType, Digest: digest}}}
+	if _, err := manifest.Layer(); err == nil {
+		t.Error("expected a manifest layer with a path to fail")
+	}
+}
+
+func TestLayoutTamperedBlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := Layout{Dir: dir}
+
+	blob := []byte("blob")
+	digest := Digest(blob)
+
+	err = layout.WriteBlob(digest, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := layout.VerifyBlob(digest); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(layout.BlobPath(digest), []byte("tampered"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := layout.ReadBlob(digest); err == nil {
+		t.Error("expected reading a tampered blob to fail")
+	}
+
+	if err := layout.VerifyBlob(digest); err == nil {
+		t.Error("expected verifying a tampered blob to fail")
+	}
+
+	if err := layout.WriteBlob(digest, []byte("other")); err == nil {
+		t.Error("expected writing a blob that does not match its digest to fail")
+	}
+}
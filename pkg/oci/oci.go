@@ -0,0 +1,375 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestMediaType is the media type of template manifests
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// ConfigMediaType is the media type of the template metadata config blob
+	ConfigMediaType = "application/vnd.rig.template.config.v1+json"
+	// LayerMediaType is the media type of the template archive layer
+	LayerMediaType = "application/vnd.rig.template.layer.v1.tar+gzip"
+)
+
+// Timeout is the timeout of registry requests, including reading the
+// response body
+const Timeout = 5 * time.Minute
+
+// httpClient sends registry requests
+var httpClient = &http.Client{Timeout: Timeout}
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Descriptor describes a blob in a registry
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an oci image manifest
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Layer returns the template archive layer of a manifest
+func (m *Manifest) Layer() (*Descriptor, error) {
+	for i := range m.Layers {
+		if m.Layers[i].MediaType == LayerMediaType {
+			err := CheckDigest(m.Layers[i].Digest)
+			if err != nil {
+				return nil, fmt.Errorf("Manifest layer is invalid: %s", err)
+			}
+			return &m.Layers[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Manifest does not contain a rig template: no layer of type %s", LayerMediaType)
+}
+
+// Digest returns the digest of a blob
+func Digest(blob []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+}
+
+// Client is a client for a repository in an oci registry. Registries on
+// localhost are accessed with http, all others with https
+type Client struct {
+	Host       string
+	Repository string
+	Username   string
+	Token      string
+
+	bearer string
+}
+
+// AuthError is returned when a registry denies access
+type AuthError struct {
+	URL    string
+	Status string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Authentication failed for %s: %s", e.URL, e.Status)
+}
+
+// Manifest returns a manifest and its digest. The reference is either a tag
+// or a manifest digest
+func (c *Client) Manifest(ctx context.Context, reference string) ([]byte, string, error) {
+	res, err := c.do(ctx, http.MethodGet, c.url("manifests", reference), nil, map[string]string{"Accept": ManifestMediaType})
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", c.statusError(res, fmt.Sprintf("manifest %s", reference))
+	}
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	digest := Digest(blob)
+
+	if digestRegexp.MatchString(reference) && digest != reference {
+		return nil, "", fmt.Errorf("Manifest %s of %s does not match its digest", reference, c.Repository)
+	}
+
+	return blob, digest, nil
+}
+
+// Blob returns a blob and verifies it against its digest
+func (c *Client) Blob(ctx context.Context, digest string) ([]byte, error) {
+	err := CheckDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(ctx, http.MethodGet, c.url("blobs", digest), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, c.statusError(res, fmt.Sprintf("blob %s", digest))
+	}
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if Digest(blob) != digest {
+		return nil, fmt.Errorf("Blob %s of %s does not match its digest", digest, c.Repository)
+	}
+
+	return blob, nil
+}
+
+// PushBlob uploads a blob unless the registry already has it
+func (c *Client) PushBlob(ctx context.Context, blob []byte) (string, error) {
+	digest := Digest(blob)
+
+	res, err := c.do(ctx, http.MethodHead, c.url("blobs", digest), nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return digest, nil
+	}
+
+	res, err = c.do(ctx, http.MethodPost, c.url("blobs", "uploads/"), nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return "", c.statusError(res, "blob upload")
+	}
+
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", err
+	}
+
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	res, err = c.do(ctx, http.MethodPut, location.String(), blob, map[string]string{"Content-Type": "application/octet-stream"})
+	if err != nil {
+		return "", err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return "", c.statusError(res, fmt.Sprintf("blob %s", digest))
+	}
+
+	return digest, nil
+}
+
+// PushManifest uploads a manifest and tags it. The manifest digest is returned
+func (c *Client) PushManifest(ctx context.Context, manifest []byte, tag string) (string, error) {
+	res, err := c.do(ctx, http.MethodPut, c.url("manifests", tag), manifest, map[string]string{"Content-Type": ManifestMediaType})
+	if err != nil {
+		return "", err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return "", c.statusError(res, fmt.Sprintf("manifest %s", tag))
+	}
+
+	return Digest(manifest), nil
+}
+
+// url returns the url of a registry api endpoint of the repository
+func (c *Client) url(kind string, reference string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", c.scheme(), c.Host, c.Repository, kind, reference)
+}
+
+// scheme returns http for registries on localhost and https for all others
+func (c *Client) scheme() string {
+	host := c.Host
+	if h, _, err := net.SplitHostPort(c.Host); err == nil {
+		host = h
+	}
+
+	if host == "localhost" || net.ParseIP(host).IsLoopback() {
+		return "http"
+	}
+
+	return "https"
+}
+
+// do sends a request to the registry. Requests are retried once with
+// credentials when the registry asks for authentication
+func (c *Client) do(ctx context.Context, method string, url string, body []byte, headers map[string]string) (*http.Response, error) {
+	res, err := c.send(ctx, method, url, body, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+
+	res.Body.Close()
+
+	err = c.authenticate(ctx, res.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.send(ctx, method, url, body, headers)
+}
+
+func (c *Client) send(ctx context.Context, method string, url string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	} else if c.Token != "" {
+		req.SetBasicAuth(c.Username, c.Token)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach registry %s: %s", c.Host, err)
+	}
+
+	return res, nil
+}
+
+// authenticate handles a registry auth challenge. Basic auth challenges are
+// answered with the configured credentials. For bearer challenges a token is
+// requested from the auth server
+func (c *Client) authenticate(ctx context.Context, challenge string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		if c.Token == "" || c.bearer != "" {
+			return &AuthError{URL: c.Host + "/" + c.Repository, Status: "401 Unauthorized"}
+		}
+
+		return nil
+	}
+
+	params := make(map[string]string)
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+
+	if params["realm"] == "" {
+		return fmt.Errorf("Invalid auth challenge from registry %s: %s", c.Host, challenge)
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil {
+		return err
+	}
+
+	query := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+
+	if c.Token != "" {
+		req.SetBasicAuth(c.Username, c.Token)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to reach auth server of registry %s: %s", c.Host, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &AuthError{URL: tokenURL.String(), Status: res.Status}
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token)
+	if err != nil {
+		return fmt.Errorf("Invalid token response from registry %s: %s", c.Host, err)
+	}
+
+	c.bearer = token.Token
+	if c.bearer == "" {
+		c.bearer = token.AccessToken
+	}
+
+	if c.bearer == "" {
+		return errors.New("Registry auth server returned an empty token")
+	}
+
+	return nil
+}
+
+// statusError returns an error for an unexpected registry response
+func (c *Client) statusError(res *http.Response, what string) error {
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return &AuthError{URL: c.Host + "/" + c.Repository, Status: res.Status}
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s/%s: %s not found", c.Host, c.Repository, what)
+	}
+
+	return fmt.Errorf("%s/%s: unexpected response for %s: %s", c.Host, c.Repository, what, res.Status)
+}
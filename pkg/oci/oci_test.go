@@ -0,0 +1,188 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registry is an in-memory registry for a single repository. Requests need a
+// bearer token which the token endpoint hands out for the basic auth token
+type registry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	token     string
+}
+
+func newRegistry(token string) (*httptest.Server, *registry) {
+	reg := &registry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, token: token}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reg.serve(w, r, server.URL)
+	}))
+
+	return server, reg
+}
+
+func (reg *registry) serve(w http.ResponseWriter, r *http.Request, serverURL string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if _, password, _ := r.BasicAuth(); password != reg.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"token": "bearer"})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer bearer" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+serverURL+`/token",service="registry",scope="repository:templates/app:pull,push"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	prefix := "/v2/templates/app/"
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == prefix+"blobs/uploads/":
+		w.Header().Set("Location", "/upload")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && r.URL.Path == "/upload":
+		blob, _ := ioutil.ReadAll(r.Body)
+		reg.blobs[r.URL.Query().Get("digest")] = blob
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(r.URL.Path, prefix+"blobs/"):
+		blob, ok := reg.blobs[strings.TrimPrefix(r.URL.Path, prefix+"blobs/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(blob)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, prefix+"manifests/"):
+		manifest, _ := ioutil.ReadAll(r.Body)
+		reg.manifests[strings.TrimPrefix(r.URL.Path, prefix+"manifests/")] = manifest
+		reg.manifests[Digest(manifest)] = manifest
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(r.URL.Path, prefix+"manifests/"):
+		manifest, ok := reg.manifests[strings.TrimPrefix(r.URL.Path, prefix+"manifests/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", ManifestMediaType)
+		w.Write(manifest)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func client(server *httptest.Server, token string) *Client {
+	return &Client{
+		Host:       strings.TrimPrefix(server.URL, "http://"),
+		Repository: "templates/app",
+		Username:   "rig",
+		Token:      token,
+	}
+}
+
+func TestPushAndPull(t *testing.T) {
+	server, _ := newRegistry("token")
+	defer server.Close()
+
+	ctx := context.Background()
+	c := client(server, "token")
+
+	layer := []byte("layer")
+
+	layerDigest, err := c.PushBlob(ctx, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Layers:        []Descriptor{{MediaType: LayerMediaType, Digest: layerDigest, Size: int64(len(layer))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifestDigest, err := c.PushManifest(ctx, manifest, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reference := range []string{"1.0.0", manifestDigest} {
+		pulled, digest, err := c.Manifest(ctx, reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(pulled) != string(manifest) || digest != manifestDigest {
+			t.Errorf("expected manifest %s of %s, got %s", manifestDigest, reference, digest)
+		}
+	}
+
+	blob, err := c.Blob(ctx, layerDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(blob) != string(layer) {
+		t.Errorf("expected blob %s, got %s", layer, blob)
+	}
+}
+
+func TestBlobDigestMismatch(t *testing.T) {
+	server, reg := newRegistry("token")
+	defer server.Close()
+
+	digest := Digest([]byte("layer"))
+	reg.blobs[digest] = []byte("tampered")
+
+	_, err := client(server, "token").Blob(context.Background(), digest)
+	if err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Errorf("expected a tampered blob to fail, got %v", err)
+	}
+}
+
+func TestAuthFailed(t *testing.T) {
+	server, _ := newRegistry("token")
+	defer server.Close()
+
+	_, _, err := client(server, "wrong").Manifest(context.Background(), "1.0.0")
+	if _, ok := err.(*AuthError); !ok {
+		t.Errorf("expected an auth error, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	c := httpClient
+	httpClient = &http.Client{Timeout: 100 * time.Millisecond}
+	defer func() { httpClient = c }()
+
+	_, _, err := client(server, "").Manifest(context.Background(), "1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "Unable to reach registry") {
+		t.Errorf("expected a stalled registry to time out, got %v", err)
+	}
+}
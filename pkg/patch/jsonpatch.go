@@ -0,0 +1,235 @@
+package patch
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operation is a JSON patch (RFC 6902) operation
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// applyJSON applies JSON patch operations to a document and returns the
+// patched document
+func applyJSON(doc interface{}, ops []Operation) (interface{}, error) {
+	var err error
+
+	for i, op := range ops {
+		switch op.Op {
+		case "add":
+			doc, err = add(doc, op.Path, op.Value)
+		case "remove":
+			doc, _, err = remove(doc, op.Path)
+		case "replace":
+			// Replacing the root replaces the whole document
+			if op.Path != "" {
+				doc, _, err = remove(doc, op.Path)
+			}
+			if err == nil {
+				doc, err = add(doc, op.Path, op.Value)
+			}
+		case "move":
+			var value interface{}
+			doc, value, err = remove(doc, op.From)
+			if err == nil {
+				doc, err = add(doc, op.Path, value)
+			}
+		case "copy":
+			var value interface{}
+			value, err = get(doc, op.From)
+			if err == nil {
+				doc, err = add(doc, op.Path, deepCopy(value))
+			}
+		case "test":
+			var value interface{}
+			value, err = get(doc, op.Path)
+			if err == nil && !reflect.DeepEqual(value, op.Value) {
+				err = fmt.Errorf("value at %s is not %v", op.Path, op.Value)
+			}
+		default:
+			err = fmt.Errorf("unknown op '%s'", op.Op)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i+1, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// tokens splits a JSON pointer into unescaped reference tokens
+func tokens(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid path '%s'", pointer)
+	}
+
+	parts := strings.Split(pointer[1:], "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return parts, nil
+}
+
+// index returns the index a token refers to in a list of length n. The token
+// "-" refers to the end of the list if end is true
+func index(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (i == n && !end) {
+		return 0, fmt.Errorf("invalid list index '%s'", token)
+	}
+
+	return i, nil
+}
+
+func get(doc interface{}, pointer string) (interface{}, error) {
+	toks, err := tokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tok := range toks {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[tok]
+			if !ok {
+				return nil, fmt.Errorf("path %s does not exist", pointer)
+			}
+			doc = value
+		case []interface{}:
+			i, err := index(tok, len(node), false)
+			if err != nil {
+				return nil, fmt.Errorf("path %s does not exist: %s", pointer, err)
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path %s does not exist", pointer)
+		}
+	}
+
+	return doc, nil
+}
+
+// update replaces the value at a pointer with the result of fn. The parent of
+// the pointer must exist. Lists are replaced since they can change length
+func update(doc interface{}, toks []string, pointer string, fn func(parent interface{}, tok string) (interface{}, error)) (interface{}, error) {
+	if len(toks) == 1 {
+		return fn(doc, toks[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[toks[0]]
+		if !ok {
+			return nil, fmt.Errorf("path %s does not exist", pointer)
+		}
+
+		child, err := update(child, toks[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		node[toks[0]] = child
+		return node, nil
+	case []interface{}:
+		i, err := index(toks[0], len(node), false)
+		if err != nil {
+			return nil, fmt.Errorf("path %s does not exist: %s", pointer, err)
+		}
+
+		child, err := update(node[i], toks[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		node[i] = child
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("path %s does not exist", pointer)
+}
+
+func add(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	toks, err := tokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toks) == 0 {
+		return value, nil
+	}
+
+	return update(doc, toks, pointer, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[tok] = value
+			return node, nil
+		case []interface{}:
+			i, err := index(tok, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+
+		return nil, fmt.Errorf("path %s does not exist", pointer)
+	})
+}
+
+func remove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	toks, err := tokens(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(toks) == 0 {
+		return nil, nil, fmt.Errorf("can not remove the whole document")
+	}
+
+	var removed interface{}
+
+	doc, err = update(doc, toks, pointer, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			value, ok := node[tok]
+			if !ok {
+				return nil, fmt.Errorf("path %s does not exist", pointer)
+			}
+
+			removed = value
+			delete(node, tok)
+			return node, nil
+		case []interface{}:
+			i, err := index(tok, len(node), false)
+			if err != nil {
+				return nil, fmt.Errorf("path %s does not exist: %s", pointer, err)
+			}
+
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("path %s does not exist", pointer)
+	})
+
+	return doc, removed, err
+}
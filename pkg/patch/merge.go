@@ -0,0 +1,178 @@
+package patch
+
+import "fmt"
+
+// directive is the key of strategic merge patch directives
+const directive = "$patch"
+
+// mergeKeys are the keys that identify items in lists of objects. Items of
+// lists with a merge key are merged by the key, other lists are replaced. The
+// first key present in the patch items is used
+var mergeKeys = map[string][]string{
+	"containers":          {"name"},
+	"initContainers":      {"name"},
+	"ephemeralContainers": {"name"},
+	"env":                 {"name"},
+	"volumes":             {"name"},
+	"imagePullSecrets":    {"name"},
+	"volumeMounts":        {"mountPath"},
+	"volumeDevices":       {"devicePath"},
+	"hostAliases":         {"ip"},
+	"ports":               {"containerPort", "port"},
+}
+
+// applyMerge applies a strategic merge patch to an object. Maps are merged
+// recursively, null values remove keys and lists of objects with a known merge
+// key are merged by that key. The $patch directive can be set to delete or
+// replace on maps and list items
+func applyMerge(obj map[string]interface{}, patch map[string]interface{}) (map[string]interface{}, error) {
+	switch patch[directive] {
+	case nil:
+	case "replace":
+		return without(patch).(map[string]interface{}), nil
+	case "delete":
+		return nil, fmt.Errorf("can not delete the whole object")
+	default:
+		return nil, fmt.Errorf("unknown directive %s: %v", directive, patch[directive])
+	}
+
+	for key, value := range patch {
+		if key == directive {
+			continue
+		}
+
+		switch v := value.(type) {
+		case nil:
+			delete(obj, key)
+		case map[string]interface{}:
+			if v[directive] == "delete" {
+				delete(obj, key)
+				continue
+			}
+
+			current, ok := obj[key].(map[string]interface{})
+			if !ok {
+				current = make(map[string]interface{})
+			}
+
+			merged, err := applyMerge(current, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+
+			obj[key] = merged
+		case []interface{}:
+			current, _ := obj[key].([]interface{})
+
+			merged, err := mergeList(key, current, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+
+			obj[key] = merged
+		default:
+			obj[key] = value
+		}
+	}
+
+	return obj, nil
+}
+
+// mergeList merges the items of a patch list into a list. Lists are replaced
+// by the patch items without directives if they have no known merge key
+func mergeList(field string, list []interface{}, patch []interface{}) ([]interface{}, error) {
+	var items []interface{}
+	for _, item := range patch {
+		if m, ok := item.(map[string]interface{}); ok && m[directive] == "replace" && len(m) == 1 {
+			return without(patch).([]interface{}), nil
+		}
+		items = append(items, item)
+	}
+
+	key := mergeKey(field, items)
+	if key == "" {
+		return without(items).([]interface{}), nil
+	}
+
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok || m[key] == nil {
+			return nil, fmt.Errorf("all items must be objects with a %s", key)
+		}
+
+		i := find(list, key, m[key])
+
+		if m[directive] == "delete" {
+			if i != -1 {
+				list = append(list[:i], list[i+1:]...)
+			}
+			continue
+		}
+
+		if i == -1 {
+			list = append(list, without(m))
+			continue
+		}
+
+		current, _ := list[i].(map[string]interface{})
+
+		merged, err := applyMerge(current, m)
+		if err != nil {
+			return nil, err
+		}
+
+		list[i] = merged
+	}
+
+	return list, nil
+}
+
+// mergeKey returns the merge key of a list field that is present in the patch
+// items
+func mergeKey(field string, items []interface{}) string {
+	for _, key := range mergeKeys[field] {
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok && m[key] != nil {
+				return key
+			}
+		}
+	}
+
+	return ""
+}
+
+// find returns the index of the item in a list with a merge key value or -1
+func find(list []interface{}, key string, value interface{}) int {
+	for i, item := range list {
+		if m, ok := item.(map[string]interface{}); ok && fmt.Sprint(m[key]) == fmt.Sprint(value) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// without returns a value with all patch directives removed
+func without(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for key, item := range v {
+			if key != directive {
+				m[key] = without(item)
+			}
+		}
+		return m
+	case []interface{}:
+		var list []interface{}
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok && m[directive] != nil && len(m) == 1 {
+				continue
+			}
+			list = append(list, without(item))
+		}
+		return list
+	}
+
+	return value
+}
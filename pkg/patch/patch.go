@@ -0,0 +1,254 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/gonstr/rig/pkg/manifest"
+)
+
+// Target selects the objects a patch is applied to. Empty fields match all
+// objects
+type Target struct {
+	Kind      string            `json:"kind,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// Patch is a strategic merge patch or a JSON patch (RFC 6902). Patches can be
+// inline or read from a file relative to rig.yaml. Strategic merge patches
+// without a target are applied to the object with the kind and name of the
+// patch
+type Patch struct {
+	Target    *Target                `json:"target,omitempty"`
+	Patch     map[string]interface{} `json:"patch,omitempty"`
+	JSONPatch []Operation            `json:"jsonPatch,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+}
+
+// FromMap returns the patches of the parsed contents of a rig file. Patch
+// files are read relative to the rig file
+func FromMap(file map[string]interface{}, filePath string) ([]Patch, error) {
+	raw, ok := file["patches"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var patches []Patch
+
+	err = json.Unmarshal(bytes, &patches)
+	if err != nil {
+		return nil, fmt.Errorf("%s is malformed: could not parse patches: %s", filePath, err)
+	}
+
+	for i := range patches {
+		p := &patches[i]
+
+		if p.Path != "" {
+			if p.Patch != nil || p.JSONPatch != nil {
+				return nil, fmt.Errorf("%s is malformed: patch %d can not have both a path and an inline patch", filePath, i+1)
+			}
+
+			err = p.read(path.Join(path.Dir(filePath), p.Path))
+			if err != nil {
+				return nil, fmt.Errorf("%s: patch %d: %s", filePath, i+1, err)
+			}
+		}
+
+		if (p.Patch == nil) == (p.JSONPatch == nil) {
+			return nil, fmt.Errorf("%s is malformed: patch %d must have either a patch, a jsonPatch or a path", filePath, i+1)
+		}
+
+		if p.Target == nil {
+			if p.JSONPatch != nil {
+				return nil, fmt.Errorf("%s is malformed: json patch %d must have a target", filePath, i+1)
+			}
+
+			p.Target = &Target{Kind: manifest.Kind(p.Patch), Name: manifest.Name(p.Patch)}
+
+			if p.Target.Kind == "" || p.Target.Name == "" {
+				return nil, fmt.Errorf("%s is malformed: patch %d must have a target or a kind and a name", filePath, i+1)
+			}
+		}
+	}
+
+	return patches, nil
+}
+
+// read reads a patch file. Files containing a list are JSON patches, files
+// containing a map strategic merge patches
+func (p *Patch) read(filePath string) error {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	var content interface{}
+
+	err = yaml.Unmarshal(bytes, &content)
+	if err != nil {
+		return err
+	}
+
+	switch content.(type) {
+	case []interface{}:
+		return yaml.Unmarshal(bytes, &p.JSONPatch)
+	case map[string]interface{}:
+		return yaml.Unmarshal(bytes, &p.Patch)
+	}
+
+	return fmt.Errorf("%s must contain a strategic merge patch or a list of JSON patch operations", filePath)
+}
+
+// Apply applies patches in order to the objects of a manifest. An error is
+// returned if a patch does not match any object. The manifest is returned as
+// is if there are no patches
+func Apply(patches []Patch, str string) (string, error) {
+	if len(patches) == 0 {
+		return str, nil
+	}
+
+	objs, err := manifest.Parse(str)
+	if err != nil {
+		return "", err
+	}
+
+	for i, p := range patches {
+		matched := false
+
+		for j, obj := range objs {
+			if !p.Target.matches(obj) {
+				continue
+			}
+
+			matched = true
+
+			objs[j], err = p.apply(obj)
+			if err != nil {
+				return "", fmt.Errorf("Patch %d (%s) failed on %s/%s: %s", i+1, p.Target, manifest.Kind(obj), manifest.Name(obj), err)
+			}
+		}
+
+		if !matched {
+			return "", fmt.Errorf("Patch %d (%s) did not match any resource", i+1, p.Target)
+		}
+	}
+
+	return manifest.String(objs)
+}
+
+// apply applies a patch to a copy of an object
+func (p Patch) apply(obj map[string]interface{}) (map[string]interface{}, error) {
+	obj = deepCopy(obj).(map[string]interface{})
+
+	if p.Patch != nil {
+		return applyMerge(obj, deepCopy(p.Patch).(map[string]interface{}))
+	}
+
+	doc, err := applyJSON(obj, deepCopy(p.JSONPatch).([]Operation))
+	if err != nil {
+		return nil, err
+	}
+
+	patched, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the patched object is not an object")
+	}
+
+	return patched, nil
+}
+
+func (t *Target) matches(obj map[string]interface{}) bool {
+	if t.Kind != "" && t.Kind != manifest.Kind(obj) {
+		return false
+	}
+
+	if t.Name != "" && t.Name != manifest.Name(obj) {
+		return false
+	}
+
+	if t.Namespace != "" && t.Namespace != manifest.Namespace(obj) {
+		return false
+	}
+
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+
+	for k, v := range t.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns a description of a target, e.g. kind=Deployment,name=app
+func (t *Target) String() string {
+	var parts []string
+
+	if t.Kind != "" {
+		parts = append(parts, "kind="+t.Kind)
+	}
+
+	if t.Name != "" {
+		parts = append(parts, "name="+t.Name)
+	}
+
+	if t.Namespace != "" {
+		parts = append(parts, "namespace="+t.Namespace)
+	}
+
+	var labels []string
+	for k, v := range t.Labels {
+		labels = append(labels, fmt.Sprintf("labels.%s=%s", k, v))
+	}
+
+	sort.Strings(labels)
+	parts = append(parts, labels...)
+
+	if len(parts) == 0 {
+		return "all resources"
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// deepCopy returns a deep copy of a value
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = deepCopy(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = deepCopy(item)
+		}
+		return list
+	case []Operation:
+		ops := make([]Operation, len(v))
+		for i, op := range v {
+			op.Value = deepCopy(op.Value)
+			ops[i] = op
+		}
+		return ops
+	}
+
+	return value
+}
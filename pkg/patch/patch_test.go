@@ -0,0 +1,149 @@
+package patch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, test := range map[string]struct {
+		content string
+		json    bool
+	}{
+		"merge patch":                {"spec:\n  replicas: 2\n", false},
+		"merge patch with separator": {"---\nspec:\n  replicas: 2\n", false},
+		"json patch":                 {"- op: replace\n  path: /spec/replicas\n  value: 2\n", true},
+		"json patch with comment":    {"# scale up\n- op: replace\n  path: /spec/replicas\n  value: 2\n", true},
+		"json patch as json":         {`[{"op": "replace", "path": "/spec/replicas", "value": 2}]`, true},
+	} {
+		filePath := path.Join(dir, "patch.yaml")
+
+		err := ioutil.WriteFile(filePath, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var p Patch
+
+		err = p.read(filePath)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if test.json && len(p.JSONPatch) != 1 {
+			t.Errorf("%s: expected a JSON patch, got %v", name, p)
+		}
+
+		if !test.json && p.Patch == nil {
+			t.Errorf("%s: expected a strategic merge patch, got %v", name, p)
+		}
+	}
+
+	filePath := path.Join(dir, "patch.yaml")
+
+	err = ioutil.WriteFile(filePath, []byte("replicas"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p Patch
+
+	err = p.read(filePath)
+	if err == nil {
+		t.Error("expected a patch file without a map or a list to fail")
+	}
+}
+
+func TestReplaceRoot(t *testing.T) {
+	doc := map[string]interface{}{"kind": "Service"}
+	value := map[string]interface{}{"kind": "Deployment"}
+
+	patched, err := applyJSON(doc, []Operation{{Op: "replace", Path: "", Value: value}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(patched, value) {
+		t.Errorf("expected %v, got %v", value, patched)
+	}
+}
+
+func TestMergeDirectives(t *testing.T) {
+	for name, test := range map[string]struct {
+		obj      map[string]interface{}
+		patch    map[string]interface{}
+		expected map[string]interface{}
+	}{
+		"list without merge key": {
+			obj: map[string]interface{}{"args": []interface{}{"a"}},
+			patch: map[string]interface{}{"args": []interface{}{
+				map[string]interface{}{"flag": "b", "opts": map[string]interface{}{"$patch": "replace", "x": 1}},
+			}},
+			expected: map[string]interface{}{"args": []interface{}{
+				map[string]interface{}{"flag": "b", "opts": map[string]interface{}{"x": 1}},
+			}},
+		},
+		"nested list without merge key": {
+			obj: map[string]interface{}{"rules": []interface{}{}},
+			patch: map[string]interface{}{"rules": []interface{}{
+				map[string]interface{}{"hosts": []interface{}{map[string]interface{}{"$patch": "replace"}, "a.example.com"}},
+			}},
+			expected: map[string]interface{}{"rules": []interface{}{
+				map[string]interface{}{"hosts": []interface{}{"a.example.com"}},
+			}},
+		},
+		"new item with merge key": {
+			obj: map[string]interface{}{"containers": []interface{}{}},
+			patch: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "resources": map[string]interface{}{"$patch": "replace", "limits": nil}},
+			}},
+			expected: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "app", "resources": map[string]interface{}{"limits": nil}},
+			}},
+		},
+		"delete item with merge key": {
+			obj: map[string]interface{}{"env": []interface{}{
+				map[string]interface{}{"name": "A", "value": "1"},
+				map[string]interface{}{"name": "B", "value": "2"},
+			}},
+			patch: map[string]interface{}{"env": []interface{}{
+				map[string]interface{}{"name": "A", "$patch": "delete"},
+			}},
+			expected: map[string]interface{}{"env": []interface{}{
+				map[string]interface{}{"name": "B", "value": "2"},
+			}},
+		},
+		"replace list": {
+			obj: map[string]interface{}{"env": []interface{}{
+				map[string]interface{}{"name": "A", "value": "1"},
+			}},
+			patch: map[string]interface{}{"env": []interface{}{
+				map[string]interface{}{"$patch": "replace"},
+				map[string]interface{}{"name": "B", "value": "2"},
+			}},
+			expected: map[string]interface{}{"env": []interface{}{
+				map[string]interface{}{"name": "B", "value": "2"},
+			}},
+		},
+	} {
+		merged, err := applyMerge(test.obj, test.patch)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(merged, test.expected) {
+			t.Errorf("%s: expected %v, got %v", name, test.expected, merged)
+		}
+	}
+}
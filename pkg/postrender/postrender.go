@@ -0,0 +1,224 @@
+package postrender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gonstr/rig/pkg/manifest"
+	"github.com/gonstr/rig/pkg/shell"
+)
+
+// Step is a post render step. Steps either run an external command or one of
+// the built-in steps
+type Step struct {
+	Name    string            `json:"name,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Builtin string            `json:"builtin,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// builtins are the built-in steps
+var builtins = map[string]func(step Step, str string) (string, error){
+	"sort":   sortObjects,
+	"labels": addLabels,
+}
+
+// FromMap returns the post render steps of the parsed contents of a rig file.
+// The file path is only used in error messages
+func FromMap(file map[string]interface{}, filePath string) ([]Step, error) {
+	raw, ok := file["postRender"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []Step
+
+	err = json.Unmarshal(bytes, &steps)
+	if err != nil {
+		return nil, fmt.Errorf("%s is malformed: could not parse postRender: %s", filePath, err)
+	}
+
+	for i, step := range steps {
+		if (step.Command == "") == (step.Builtin == "") {
+			return nil, fmt.Errorf("%s is malformed: postRender step %d must have either a command or a builtin", filePath, i+1)
+		}
+
+		if _, ok := builtins[step.Builtin]; step.Builtin != "" && !ok {
+			return nil, fmt.Errorf("%s is malformed: unknown builtin postRender step '%s'", filePath, step.Builtin)
+		}
+	}
+
+	return steps, nil
+}
+
+// Run runs post render steps in sequence. Each step receives the output of the
+// previous step. External commands are run with sh in dir and receive the
+// manifest on stdin. Commands are killed if ctx is done. Steps that return an
+// empty manifest for a non-empty one fail
+func Run(ctx context.Context, steps []Step, str string, dir string) (string, error) {
+	for _, step := range steps {
+		var out string
+		var err error
+
+		if step.Builtin != "" {
+			out, err = builtins[step.Builtin](step, str)
+		} else {
+			out, err = run(ctx, step, str, dir)
+		}
+
+		if err == nil && strings.TrimSpace(out) == "" && strings.TrimSpace(str) != "" {
+			err = errors.New("returned an empty manifest. Steps that only check the manifest must print it unchanged")
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("Post render step '%s' failed: %s", step.String(), err)
+		}
+
+		str = out
+	}
+
+	return str, nil
+}
+
+// String returns the name of a step. Steps without a name are named after
+// their command or builtin
+func (s Step) String() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.Builtin != "":
+		return s.Builtin
+	}
+
+	return s.Command
+}
+
+func run(ctx context.Context, step Step, str string, dir string) (string, error) {
+	stdout, stderr, err := shell.Run(ctx, dir, step.Command, strings.NewReader(str+"\n"))
+	if err != nil {
+		if msg := strings.TrimSpace(string(stderr)); msg != "" {
+			return "", fmt.Errorf("%s\n%s", err, msg)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+// kindOrder is the order objects are sorted in by the sort step. Objects are
+// ordered so that dependencies are created first. Unknown kinds go last
+var kindOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// sortObjects sorts the documents of a manifest by kind, namespace and name.
+// Documents are kept as is
+func sortObjects(step Step, str string) (string, error) {
+	docs := manifest.Split(str)
+
+	type entry struct {
+		doc   string
+		order int
+		key   string
+	}
+
+	entries := make([]entry, len(docs))
+
+	for i, doc := range docs {
+		objs, err := manifest.Parse(doc)
+		if err != nil {
+			return "", err
+		}
+
+		e := entry{doc: doc, order: len(kindOrder)}
+
+		if len(objs) > 0 {
+			kind := manifest.Kind(objs[0])
+
+			for j, k := range kindOrder {
+				if k == kind {
+					e.order = j
+				}
+			}
+
+			e.key = fmt.Sprintf("%s/%s/%s", kind, manifest.Namespace(objs[0]), manifest.Name(objs[0]))
+		}
+
+		entries[i] = e
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].order != entries[j].order {
+			return entries[i].order < entries[j].order
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	for i, e := range entries {
+		docs[i] = e.doc
+	}
+
+	return manifest.Join(docs), nil
+}
+
+// addLabels adds the labels of a step to the metadata of all objects
+func addLabels(step Step, str string) (string, error) {
+	objs, err := manifest.Parse(str)
+	if err != nil {
+		return "", err
+	}
+
+	for _, obj := range objs {
+		metadata := manifest.Metadata(obj)
+
+		labels, ok := metadata["labels"].(map[string]interface{})
+		if !ok {
+			labels = make(map[string]interface{})
+			metadata["labels"] = labels
+		}
+
+		for k, v := range step.Labels {
+			labels[k] = v
+		}
+	}
+
+	return manifest.String(objs)
+}
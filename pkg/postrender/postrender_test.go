@@ -0,0 +1,47 @@
+package postrender
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const service = `kind: Service
+metadata:
+  name: app`
+
+func TestRun(t *testing.T) {
+	steps := []Step{
+		{Name: "check", Command: `m=$(cat) && printf '%s\n' "$m" | grep -q Service >&2 && printf '%s\n' "$m"`},
+		{Command: "sed s/app/web/"},
+	}
+
+	out, err := Run(context.Background(), steps, service, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Replace(service, "app", "web", 1)
+	if out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+}
+
+func TestRunEmptyOutput(t *testing.T) {
+	_, err := Run(context.Background(), []Step{{Command: "cat >/dev/null"}}, service, ".")
+	if err == nil || !strings.Contains(err.Error(), "empty manifest") {
+		t.Errorf("expected a step with empty output to fail, got %v", err)
+	}
+
+	out, err := Run(context.Background(), []Step{{Command: "cat"}}, "", ".")
+	if err != nil || out != "" {
+		t.Errorf("expected an empty manifest to pass, got %q, %v", out, err)
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	_, err := Run(context.Background(), []Step{{Name: "validate", Command: "echo invalid >&2; exit 1"}}, service, ".")
+	if err == nil || !strings.Contains(err.Error(), "'validate' failed") || !strings.Contains(err.Error(), "invalid") {
+		t.Errorf("expected the step to fail with its stderr, got %v", err)
+	}
+}
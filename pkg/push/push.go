@@ -0,0 +1,147 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gonstr/rig/pkg/archive"
+	rigcontext "github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/fetch"
+	"github.com/gonstr/rig/pkg/fs"
+	"github.com/gonstr/rig/pkg/metadata"
+	"github.com/gonstr/rig/pkg/oci"
+)
+
+// ToOCI pushes a template to an oci registry. The template is either a template
+// archive or a template dir that is packaged before it is pushed. The tag
+// defaults to the template version. The pushed url, pinned to the manifest
+// digest, is returned
+func ToOCI(ctx context.Context, templatePath string, urlString string) (string, error) {
+	tmpl, err := rigcontext.FromURL(urlString)
+	if err != nil {
+		return "", err
+	}
+
+	if tmpl.Scheme() != "oci" {
+		return "", fmt.Errorf("Templates can only be pushed to oci urls: %s", urlString)
+	}
+
+	if tmpl.ManifestDigest() != "" {
+		return "", fmt.Errorf("Templates can not be pushed to a manifest digest: %s", urlString)
+	}
+
+	tmpDir, err := fs.TempDir()
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := templatePath
+
+	info, err := os.Stat(templatePath)
+	if err != nil {
+		return "", err
+	}
+
+	if info.IsDir() {
+		archivePath, _, err = archive.Package(templatePath, path.Join(tmpDir, "package"))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	templateDir, err := archive.Extract(archivePath, path.Join(tmpDir, "extract"))
+	if err != nil {
+		return "", err
+	}
+
+	meta, err := metadata.FromDir(templateDir)
+	if err != nil {
+		return "", err
+	}
+
+	tag := tmpl.Gitref()
+	if !hasTag(urlString) {
+		tag = meta.Version
+	}
+
+	creds, err := fetch.Credentials(tmpl.Host())
+	if err != nil {
+		return "", err
+	}
+
+	client := &oci.Client{
+		Host:       tmpl.Host(),
+		Repository: path.Join(tmpl.Owner(), tmpl.Repo()),
+		Username:   creds.Username,
+		Token:      creds.Token,
+	}
+
+	config, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+
+	layer, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		return "", err
+	}
+
+	configDigest, err := client.PushBlob(ctx, config)
+	if err != nil {
+		return "", authHelp(tmpl, err)
+	}
+
+	layerDigest, err := client.PushBlob(ctx, layer)
+	if err != nil {
+		return "", authHelp(tmpl, err)
+	}
+
+	manifest, err := json.Marshal(oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ManifestMediaType,
+		Config:        oci.Descriptor{MediaType: oci.ConfigMediaType, Digest: configDigest, Size: int64(len(config))},
+		Layers: []oci.Descriptor{{
+			MediaType:   oci.LayerMediaType,
+			Digest:      layerDigest,
+			Size:        int64(len(layer)),
+			Annotations: map[string]string{"org.opencontainers.image.title": path.Base(archivePath)},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	manifestDigest, err := client.PushManifest(ctx, manifest, tag)
+	if err != nil {
+		return "", authHelp(tmpl, err)
+	}
+
+	return fmt.Sprintf("oci://%s/%s:%s@%s", tmpl.Host(), client.Repository, tag, manifestDigest), nil
+}
+
+// authHelp adds instructions on how to configure credentials to auth errors
+func authHelp(tmpl rigcontext.Context, err error) error {
+	if _, ok := err.(*oci.AuthError); ok {
+		return fmt.Errorf("%s\n\n%s", err, fetch.CredentialsHelp(tmpl.Host()))
+	}
+
+	return err
+}
+
+// hasTag returns true if an oci url contains a tag
+func hasTag(urlString string) bool {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(path.Base(strings.Split(u.Path, "@")[0]), ":")
+}
@@ -0,0 +1,182 @@
+package rig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/gonstr/rig/pkg/build"
+	"github.com/gonstr/rig/pkg/manifest"
+)
+
+// Options are options for building templates with a Builder. By default the
+// rig file rig.yaml in Dir is built
+type Options struct {
+	// Dir is the working dir. RigFile, TemplatesPath and ValueFiles are
+	// resolved against it. Defaults to the current dir. Relative paths in the
+	// rig file, i.e. local templates, value files, patches and file
+	// references, are always resolved against the dir of the rig file
+	Dir string
+	// RigFile is the rig file to build. Defaults to rig.yaml
+	RigFile string
+	// TemplatesPath builds the template files at a path instead of a rig file
+	TemplatesPath string
+	// Template builds a template string instead of a rig file
+	Template string
+
+	// Values are merged on top of the values and value files of the rig file
+	Values map[string]interface{}
+	// ValueFiles are merged in order on top of the value files of the rig
+	// file. Encrypted files are decrypted in memory
+	ValueFiles []string
+	// SetValues are values in --value format, key1=val1,key2=val2. They
+	// supercede all other values
+	SetValues []string
+	// SetStringValues are values in --string-value format
+	SetStringValues []string
+
+	// Verify requires templates to be signed by a trusted key
+	Verify bool
+	// Offline builds remote templates from the cache without fetching
+	Offline bool
+	// AllowEnv lists environment variables templates can read. See the
+	// allowEnv option of rig files
+	AllowEnv []string
+
+	// Stdout is written the built manifest if it is not nil
+	Stdout io.Writer
+	// Logger logs the build steps if it is not nil
+	Logger *log.Logger
+}
+
+// Resource is an object of a built manifest
+type Resource struct {
+	APIVersion string
+	Kind       string
+	Name       string
+	Namespace  string
+	// Object is the parsed object
+	Object map[string]interface{}
+}
+
+// YAML returns the resource as yaml
+func (r Resource) YAML() ([]byte, error) {
+	return yaml.Marshal(r.Object)
+}
+
+// Builder builds templates
+type Builder struct {
+	opts Options
+}
+
+// NewBuilder returns a new Builder
+func NewBuilder(opts Options) *Builder {
+	return &Builder{opts: opts}
+}
+
+// Render builds the manifest and writes it to Stdout if set. The build is
+// stopped if ctx is done
+func (b *Builder) Render(ctx context.Context) (string, error) {
+	if b.opts.TemplatesPath != "" && b.opts.Template != "" {
+		return "", errors.New("TemplatesPath and Template can not both be set")
+	}
+
+	opts := b.buildOptions()
+
+	var output string
+	var err error
+
+	switch {
+	case b.opts.Template != "":
+		output, err = build.FromString(ctx, b.opts.Template, opts)
+	case b.opts.TemplatesPath != "":
+		output, err = build.FromTemplatesPath(ctx, b.opts.TemplatesPath, opts)
+	default:
+		output, err = build.FromRigFile(ctx, b.rigFile(), opts)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if b.opts.Stdout != nil {
+		_, err = fmt.Fprintln(b.opts.Stdout, output)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return output, nil
+}
+
+// Build builds the manifest and returns its resources. The manifest is
+// written to Stdout if set. The build is stopped if ctx is done
+func (b *Builder) Build(ctx context.Context) ([]Resource, error) {
+	output, err := b.Render(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := manifest.Parse(output)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]Resource, len(objs))
+
+	for i, obj := range objs {
+		apiVersion, _ := obj["apiVersion"].(string)
+
+		resources[i] = Resource{
+			APIVersion: apiVersion,
+			Kind:       manifest.Kind(obj),
+			Name:       manifest.Name(obj),
+			Namespace:  manifest.Namespace(obj),
+			Object:     obj,
+		}
+	}
+
+	return resources, nil
+}
+
+// WatchPaths returns the local files and dirs a build depends on
+func (b *Builder) WatchPaths() []string {
+	switch {
+	case b.opts.Template != "":
+		return nil
+	case b.opts.TemplatesPath != "":
+		if b.opts.Dir != "" && !path.IsAbs(b.opts.TemplatesPath) {
+			return []string{path.Join(b.opts.Dir, b.opts.TemplatesPath)}
+		}
+		return []string{b.opts.TemplatesPath}
+	}
+
+	return build.WatchPaths(b.rigFile(), b.buildOptions())
+}
+
+func (b *Builder) rigFile() string {
+	if b.opts.RigFile != "" {
+		return b.opts.RigFile
+	}
+
+	return "rig.yaml"
+}
+
+func (b *Builder) buildOptions() build.Options {
+	return build.Options{
+		Dir:          b.opts.Dir,
+		ValueMap:     b.opts.Values,
+		ValueFiles:   b.opts.ValueFiles,
+		Values:       b.opts.SetValues,
+		StringValues: b.opts.SetStringValues,
+		Verify:       b.opts.Verify,
+		Offline:      b.opts.Offline,
+		AllowEnv:     b.opts.AllowEnv,
+		Logger:       b.opts.Logger,
+	}
+}
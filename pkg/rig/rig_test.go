@@ -0,0 +1,168 @@
+package rig
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// setup writes a rig file with a local template to a temp dir with a temp home
+// dir and returns the dir
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rig-rig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+	os.Setenv("RIG_CACHE_DIR", path.Join(dir, "cache"))
+
+	for name, content := range map[string]string{
+		"rig.yaml":                             "template:\n  path: ./simple-app\nvalues:\n  name: app\n  replicas: 1\nvalueFiles:\n  - values-prod.yaml\nnamespace: my-app\n",
+		"values-prod.yaml":                     "replicas: 2\n",
+		"simple-app/templates/service.yaml":    "apiVersion: v1\nkind: Service\nmetadata:\n  name: {{ .values.name }}",
+		"simple-app/templates/deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: {{ .values.name }}\nspec:\n  replicas: {{ .values.replicas }}",
+	} {
+		filePath := path.Join(dir, name)
+
+		err := os.MkdirAll(path.Dir(filePath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+const expected = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: my-app
+spec:
+  replicas: 3
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: my-app`
+
+func TestBuilderRender(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	var stdout bytes.Buffer
+
+	output, err := NewBuilder(Options{Dir: dir, SetValues: []string{"replicas=3"}, Stdout: &stdout}).Render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != expected {
+		t.Errorf("expected %s, got %s", expected, output)
+	}
+
+	if stdout.String() != expected+"\n" {
+		t.Errorf("expected the manifest to be written to Stdout, got %s", stdout.String())
+	}
+}
+
+func TestBuilderBuild(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	resources, err := NewBuilder(Options{Dir: dir, Values: map[string]interface{}{"name": "other"}}).Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual [][]string
+	for _, r := range resources {
+		actual = append(actual, []string{r.APIVersion, r.Kind, r.Name, r.Namespace})
+	}
+
+	expected := [][]string{
+		{"apps/v1", "Deployment", "other", "my-app"},
+		{"v1", "Service", "other", "my-app"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// Value files of the rig file are merged on top of its values
+	spec, _ := resources[0].Object["spec"].(map[string]interface{})
+	if spec["replicas"] != float64(2) {
+		t.Errorf("expected 2 replicas from values-prod.yaml, got %v", spec["replicas"])
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	for name, opts := range map[string]Options{
+		"templates path and template": {Dir: dir, TemplatesPath: "simple-app", Template: "kind: Service"},
+		"missing value":               {Dir: dir, Template: "name: {{ .values.missing.name }}"},
+		"missing rig file":            {Dir: dir, RigFile: "missing.yaml"},
+	} {
+		var stdout bytes.Buffer
+		opts.Stdout = &stdout
+
+		_, err := NewBuilder(opts).Render(context.Background())
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+
+		if stdout.Len() > 0 {
+			t.Errorf("%s: expected nothing to be written to Stdout, got %s", name, stdout.String())
+		}
+	}
+}
+
+func TestBuilderWatchPaths(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(dir)
+
+	for name, test := range map[string]struct {
+		opts     Options
+		expected []string
+	}{
+		"rig file": {
+			Options{Dir: dir, ValueFiles: []string{"values-local.yaml"}},
+			[]string{
+				path.Join(dir, "rig.yaml"),
+				path.Join(dir, "values-prod.yaml"),
+				path.Join(dir, "values-local.yaml"),
+				path.Join(dir, "simple-app"),
+			},
+		},
+		"templates path": {
+			Options{Dir: dir, TemplatesPath: "simple-app/templates"},
+			[]string{path.Join(dir, "simple-app/templates")},
+		},
+		"template": {
+			Options{Dir: dir, Template: "kind: Service"},
+			nil,
+		},
+	} {
+		actual := NewBuilder(test.opts).WatchPaths()
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/gonstr/rig/pkg/age"
+	"github.com/gonstr/rig/pkg/config"
+	"github.com/gonstr/rig/pkg/fs"
+)
+
+// KeyFile returns the path of the age key file. It can be set with the
+// RIG_AGE_KEY_FILE environment variable and defaults to ~/.rig/age.key
+func KeyFile() (string, error) {
+	if keyFile := os.Getenv("RIG_AGE_KEY_FILE"); keyFile != "" {
+		return homedir.Expand(keyFile)
+	}
+
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, "age.key"), nil
+}
+
+// Identities returns the age identities used to decrypt values files. They are
+// read from the RIG_AGE_KEY environment variable and the age key file
+func Identities() ([]*age.Identity, error) {
+	var identities []*age.Identity
+
+	if key := os.Getenv("RIG_AGE_KEY"); key != "" {
+		ids, err := age.ParseIdentities([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("RIG_AGE_KEY is malformed: %s", err)
+		}
+
+		identities = append(identities, ids...)
+	}
+
+	keyFile, err := KeyFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.PathExists(keyFile) {
+		bytes, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err := age.ParseIdentities(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s is malformed: %s", keyFile, err)
+		}
+
+		identities = append(identities, ids...)
+	}
+
+	return identities, nil
+}
+
+// Keygen generates a new age identity and writes it to the key file. Existing
+// key files are never overwritten
+func Keygen() (*age.Identity, string, error) {
+	keyFile, err := KeyFile()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fs.PathExists(keyFile) {
+		return nil, "", fmt.Errorf("%s already exists", keyFile)
+	}
+
+	identity, err := age.GenerateIdentity()
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = fs.EnsureDir(path.Dir(keyFile))
+	if err != nil {
+		return nil, "", err
+	}
+
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+
+	err = ioutil.WriteFile(keyFile, []byte(content), 0600)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return identity, keyFile, nil
+}
+
+// RecipientsFile lists the age recipients of the encrypted values files in
+// its dir, one per line
+const RecipientsFile = ".age-recipients"
+
+// Recipients returns the age recipients of a values file. Files are encrypted
+// to the recipients in the recipients file next to them and any additional
+// recipients. Without a recipients file, SOPS files keep their recipients and
+// other files are encrypted to the local identities
+func Recipients(filePath string, additional []string) ([]*age.Recipient, error) {
+	strs, err := baseRecipients(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []*age.Recipient
+	seen := map[string]bool{}
+
+	for _, str := range append(strs, additional...) {
+		r, err := age.ParseRecipient(str)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[r.String()] {
+			seen[r.String()] = true
+			recipients = append(recipients, r)
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("No age recipients. Supply recipients with --recipient or generate a key with 'rig secrets keygen'")
+	}
+
+	return recipients, nil
+}
+
+// baseRecipients returns the recipients of a values file before additional
+// recipients are added
+func baseRecipients(filePath string) ([]string, error) {
+	var strs []string
+
+	recipientsFile := path.Join(path.Dir(filePath), RecipientsFile)
+
+	if fs.PathExists(recipientsFile) {
+		bytes, err := ioutil.ReadFile(recipientsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(bytes), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				strs = append(strs, line)
+			}
+		}
+
+		return strs, nil
+	}
+
+	if fs.PathExists(filePath) {
+		bytes, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if strs, err := sopsRecipients(bytes); err == nil {
+			return strs, nil
+		}
+	}
+
+	identities, err := Identities()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, identity := range identities {
+		strs = append(strs, identity.Recipient().String())
+	}
+
+	return strs, nil
+}
+
+// ReadFile reads a values file. Encrypted files are decrypted in memory
+func ReadFile(filePath string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sops := isSOPS(bytes)
+
+	if !sops && !age.IsEncrypted(bytes) {
+		return bytes, nil
+	}
+
+	identities, err := Identities()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("Unable to decrypt %s: no age key. Set RIG_AGE_KEY or RIG_AGE_KEY_FILE or generate a key with 'rig secrets keygen'", filePath)
+	}
+
+	var plaintext []byte
+
+	if sops {
+		plaintext, err = decryptSOPS(bytes, identities)
+	} else {
+		plaintext, err = age.Decrypt(bytes, identities...)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt %s: %s", filePath, err)
+	}
+
+	return plaintext, nil
+}
+
+// EncryptFile encrypts a values file in place. Files are encrypted with age
+// or, if sops is true, in the SOPS format
+func EncryptFile(filePath string, recipients []*age.Recipient, sops bool) error {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if age.IsEncrypted(bytes) || isSOPS(bytes) {
+		return fmt.Errorf("%s is already encrypted", filePath)
+	}
+
+	err = validate(filePath, bytes)
+	if err != nil {
+		return err
+	}
+
+	return write(filePath, bytes, recipients, sops)
+}
+
+// Rekey encrypts a values file again, e.g. after recipients have been added
+// to or removed from the recipients file
+func Rekey(filePath string, recipients []*age.Recipient) error {
+	plaintext, err := ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	sops, err := isSOPSFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return write(filePath, plaintext, recipients, sops)
+}
+
+// Edit decrypts a values file to a temp file, opens it in $EDITOR and encrypts
+// it again when the editor exits. The editor is opened again if the edited
+// file is malformed. New files are created with age or, if sops is true, in
+// the SOPS format. Existing files keep their format
+func Edit(filePath string, recipients []*age.Recipient, sops bool) error {
+	var plaintext []byte
+
+	if fs.PathExists(filePath) {
+		var err error
+		plaintext, err = ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		sops, err = isSOPSFile(filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := fs.TempDir()
+	if err != nil {
+		return err
+	}
+
+	tmpFile := path.Join(tmpDir, path.Base(filePath))
+
+	err = ioutil.WriteFile(tmpFile, plaintext, 0600)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+
+	edited, err := edit(filePath, tmpFile, plaintext)
+	if err != nil {
+		// The temp file is kept so edits of malformed files are not lost
+		if edited == nil {
+			os.RemoveAll(tmpDir)
+		}
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	if fs.PathExists(filePath) && bytes.Equal(edited, plaintext) {
+		return nil
+	}
+
+	return write(filePath, edited, recipients, sops)
+}
+
+// edit opens tmpFile in $EDITOR until its content is a valid values file or
+// the user gives up. The edited content is returned with an error if the
+// temp file has to be kept
+func edit(filePath string, tmpFile string, plaintext []byte) ([]byte, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	args := append(strings.Fields(editor), tmpFile)
+	stdin := bufio.NewReader(os.Stdin)
+
+	for {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err := cmd.Run()
+		if err != nil {
+			return nil, fmt.Errorf("Editor %s failed: %s", editor, err)
+		}
+
+		edited, err := ioutil.ReadFile(tmpFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(edited, plaintext) {
+			return edited, nil
+		}
+
+		err = validate(filePath, edited)
+		if err == nil {
+			return edited, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "%s\nPress enter to edit the file again or Ctrl+C to abort\n", err)
+
+		_, readErr := stdin.ReadString('\n')
+		if readErr != nil {
+			return edited, fmt.Errorf("%s. Edits are kept unencrypted in %s", err, tmpFile)
+		}
+	}
+}
+
+// isSOPSFile returns true if a values file is a SOPS file
+func isSOPSFile(filePath string) (bool, error) {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	return isSOPS(bytes), nil
+}
+
+// write encrypts a values file and updates the recipients file next to it
+func write(filePath string, plaintext []byte, recipients []*age.Recipient, sops bool) error {
+	var encrypted []byte
+	var err error
+
+	if sops {
+		encrypted, err = encryptSOPS(plaintext, recipients)
+	} else {
+		encrypted, err = age.Encrypt(plaintext, recipients...)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filePath, encrypted, 0644)
+	if err != nil {
+		return err
+	}
+
+	return writeRecipients(filePath, recipients)
+}
+
+// writeRecipients writes the recipients file of a values file so the next
+// edit is encrypted to the same recipients
+func writeRecipients(filePath string, recipients []*age.Recipient) error {
+	var buf bytes.Buffer
+
+	buf.WriteString("# age recipients of the encrypted values files in this dir\n")
+	for _, r := range recipients {
+		buf.WriteString(r.String() + "\n")
+	}
+
+	recipientsFile := path.Join(path.Dir(filePath), RecipientsFile)
+
+	if existing, err := ioutil.ReadFile(recipientsFile); err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return nil
+	}
+
+	return ioutil.WriteFile(recipientsFile, buf.Bytes(), 0644)
+}
+
+// validate returns an error if a values file is not a yaml map
+func validate(filePath string, bytes []byte) error {
+	var m map[string]interface{}
+
+	err := yaml.Unmarshal(bytes, &m)
+	if err != nil {
+		return fmt.Errorf("%s is malformed: %s", filePath, err)
+	}
+
+	return nil
+}
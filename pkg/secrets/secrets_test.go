@@ -0,0 +1,320 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/gonstr/rig/pkg/age"
+)
+
+const values = `db:
+  user: app
+  password: hunter2!
+  port: 5432
+  ssl: true
+hosts:
+  - a.example.com
+  - b.example.com
+name_unencrypted: plain
+`
+
+// setup generates a local age key in a temp home dir and returns a dir for
+// values files
+func setup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rig-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+	os.Unsetenv("RIG_AGE_KEY")
+	os.Unsetenv("RIG_AGE_KEY_FILE")
+
+	_, _, err = Keygen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valuesDir := path.Join(dir, "app")
+
+	err = os.MkdirAll(valuesDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return valuesDir
+}
+
+func writeFile(t *testing.T, filePath string, content string) {
+	err := ioutil.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encrypt(t *testing.T, filePath string, additional []string, sops bool) {
+	recipients, err := Recipients(filePath, additional)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = EncryptFile(filePath, recipients, sops)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertValues(t *testing.T, filePath string, expected string) {
+	decrypted, err := ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actualMap, expectedMap map[string]interface{}
+
+	err = yaml.Unmarshal(decrypted, &actualMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = yaml.Unmarshal([]byte(expected), &expectedMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actualMap, expectedMap) {
+		t.Errorf("expected %v, got %v", expectedMap, actualMap)
+	}
+}
+
+func TestEncryptFile(t *testing.T) {
+	for _, sops := range []bool{false, true} {
+		dir := setup(t)
+		defer os.RemoveAll(path.Dir(dir))
+
+		filePath := path.Join(dir, "secrets.yaml")
+		writeFile(t, filePath, values)
+
+		encrypt(t, filePath, nil, sops)
+
+		encrypted, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(encrypted), "hunter2!") {
+			t.Error("expected values to be encrypted")
+		}
+
+		if sops != isSOPS(encrypted) || sops == age.IsEncrypted(encrypted) {
+			t.Errorf("expected sops format to be %t", sops)
+		}
+
+		if sops && !strings.Contains(string(encrypted), "name_unencrypted: plain") {
+			t.Error("expected values with the unencrypted suffix to be left unencrypted")
+		}
+
+		assertValues(t, filePath, values)
+
+		err = EncryptFile(filePath, nil, sops)
+		if err == nil {
+			t.Error("expected encrypting an encrypted file to fail")
+		}
+	}
+}
+
+func TestRecipientsFile(t *testing.T) {
+	for _, sops := range []bool{false, true} {
+		dir := setup(t)
+		defer os.RemoveAll(path.Dir(dir))
+
+		other, err := age.GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filePath := path.Join(dir, "secrets.yaml")
+		writeFile(t, filePath, values)
+
+		encrypt(t, filePath, []string{other.Recipient().String()}, sops)
+
+		recipients, err := Recipients(path.Join(dir, "other.yaml"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(recipients) != 2 || recipients[1].String() != other.Recipient().String() {
+			t.Fatalf("expected the recipients file to list the local and the other recipient, got %v", recipients)
+		}
+
+		// The other identity can decrypt the file
+		os.Setenv("RIG_AGE_KEY", other.String())
+		os.Setenv("RIG_AGE_KEY_FILE", path.Join(dir, "missing.key"))
+
+		assertValues(t, filePath, values)
+
+		os.Unsetenv("RIG_AGE_KEY_FILE")
+		os.Unsetenv("RIG_AGE_KEY")
+	}
+}
+
+func TestSOPSTampered(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(path.Dir(dir))
+
+	filePath := path.Join(dir, "secrets.yaml")
+	writeFile(t, filePath, values)
+
+	encrypt(t, filePath, nil, true)
+
+	encrypted, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(string(encrypted), "\n")
+
+	var user, password int
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "  user: "):
+			user = i
+		case strings.HasPrefix(line, "  password: "):
+			password = i
+		}
+	}
+
+	swapped := append([]string{}, lines...)
+	swapped[user] = "  user: " + strings.TrimPrefix(lines[password], "  password: ")
+	swapped[password] = "  password: " + strings.TrimPrefix(lines[user], "  user: ")
+
+	removed := append(append([]string{}, lines[:user]...), lines[user+1:]...)
+
+	unencrypted := strings.Replace(string(encrypted), "name_unencrypted: plain", "name_unencrypted: changed", 1)
+
+	for name, content := range map[string]string{
+		"swapped":     strings.Join(swapped, "\n"),
+		"removed":     strings.Join(removed, "\n"),
+		"unencrypted": unencrypted,
+	} {
+		writeFile(t, filePath, content)
+
+		_, err = ReadFile(filePath)
+		if err == nil {
+			t.Errorf("expected %s value to fail decryption", name)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	for _, sops := range []bool{false, true} {
+		dir := setup(t)
+		defer os.RemoveAll(path.Dir(dir))
+
+		filePath := path.Join(dir, "secrets.yaml")
+		writeFile(t, filePath, values)
+
+		encrypt(t, filePath, nil, sops)
+
+		editor := path.Join(dir, "editor.sh")
+		writeFile(t, editor, "#!/bin/sh\necho 'added: value' >> \"$1\"\n")
+		os.Chmod(editor, 0755)
+		os.Setenv("EDITOR", editor)
+
+		recipients, err := Recipients(filePath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = Edit(filePath, recipients, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if isSOPS(encrypted) != sops {
+			t.Errorf("expected edited file to keep the sops format %t", sops)
+		}
+
+		assertValues(t, filePath, values+"added: value\n")
+	}
+}
+
+func TestEditMalformed(t *testing.T) {
+	dir := setup(t)
+	defer os.RemoveAll(path.Dir(dir))
+
+	filePath := path.Join(dir, "secrets.yaml")
+	writeFile(t, filePath, values)
+
+	encrypt(t, filePath, nil, false)
+
+	encrypted, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor := path.Join(dir, "editor.sh")
+	writeFile(t, editor, "#!/bin/sh\necho 'malformed: [' >> \"$1\"\n")
+	os.Chmod(editor, 0755)
+	os.Setenv("EDITOR", editor)
+
+	// Closed stdin aborts instead of opening the editor again
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	stdin := os.Stdin
+	os.Stdin = devNull
+	defer func() { os.Stdin = stdin }()
+
+	recipients, err := Recipients(filePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Edit(filePath, recipients, false)
+	if err == nil {
+		t.Fatal("expected malformed edit to fail")
+	}
+
+	i := strings.Index(err.Error(), "kept unencrypted in ")
+	if i == -1 {
+		t.Fatalf("expected the temp file to be kept: %s", err)
+	}
+
+	tmpFile := err.Error()[i+len("kept unencrypted in "):]
+	defer os.RemoveAll(path.Dir(tmpFile))
+
+	kept, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(kept) != values+"malformed: [\n" {
+		t.Errorf("expected edits to be kept, got %s", kept)
+	}
+
+	unchanged, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(unchanged) != string(encrypted) {
+		t.Error("expected the values file to be left unchanged")
+	}
+}
@@ -0,0 +1,393 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/gonstr/rig/pkg/age"
+)
+
+// SOPS files are yaml or json files with encrypted values and a sops metadata
+// key (https://github.com/getsops/sops). Only age recipients are supported
+
+const (
+	sopsKey     = "sops"
+	sopsVersion = "3.7.3"
+	sopsSuffix  = "_unencrypted"
+	sopsIVSize  = 32
+)
+
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+type sopsMetadata struct {
+	Age               []sopsAgeKey `yaml:"age"`
+	LastModified      string       `yaml:"lastmodified"`
+	MAC               string       `yaml:"mac"`
+	UnencryptedSuffix string       `yaml:"unencrypted_suffix,omitempty"`
+	EncryptedSuffix   string       `yaml:"encrypted_suffix,omitempty"`
+	UnencryptedRegex  string       `yaml:"unencrypted_regex,omitempty"`
+	EncryptedRegex    string       `yaml:"encrypted_regex,omitempty"`
+	Version           string       `yaml:"version"`
+}
+
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// isSOPS returns true if data is a SOPS encrypted file
+func isSOPS(data []byte) bool {
+	_, _, err := parseSOPS(data)
+	return err == nil
+}
+
+// sopsRecipients returns the age recipients of a SOPS file
+func sopsRecipients(data []byte) ([]string, error) {
+	_, meta, err := parseSOPS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []string
+	for _, key := range meta.Age {
+		recipients = append(recipients, key.Recipient)
+	}
+
+	return recipients, nil
+}
+
+// decryptSOPS decrypts a SOPS file and returns its values as yaml
+func decryptSOPS(data []byte, identities []*age.Identity) ([]byte, error) {
+	tree, meta, err := parseSOPS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := meta.dataKey(identities)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha512.New()
+
+	decrypted, err := walkSOPS(tree, nil, func(value interface{}, path []string) (interface{}, error) {
+		if value != nil && meta.encrypted(path) {
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Value %s is not encrypted", strings.Join(path, "."))
+			}
+
+			var err error
+			value, err = decryptSOPSValue(str, dataKey, strings.Join(path, ":")+":")
+			if err != nil {
+				return nil, fmt.Errorf("Unable to decrypt value %s: %s", strings.Join(path, "."), err)
+			}
+		}
+
+		hash.Write(sopsBytes(value))
+
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := decryptSOPSValue(meta.MAC, dataKey, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt mac: %s", err)
+	}
+
+	if mac != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return nil, errors.New("MAC mismatch. The file has been tampered with")
+	}
+
+	return yaml.Marshal(decrypted)
+}
+
+// encryptSOPS encrypts the values of a yaml or json file to recipients
+func encryptSOPS(plaintext []byte, recipients []*age.Recipient) ([]byte, error) {
+	var tree yaml.MapSlice
+
+	err := yaml.Unmarshal(plaintext, &tree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range tree {
+		if item.Key == sopsKey {
+			return nil, fmt.Errorf("Values can not have a top level %s key", sopsKey)
+		}
+	}
+
+	dataKey := make([]byte, 32)
+
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := sopsMetadata{
+		LastModified:      time.Now().UTC().Format(time.RFC3339),
+		UnencryptedSuffix: sopsSuffix,
+		Version:           sopsVersion,
+	}
+
+	for _, recipient := range recipients {
+		enc, err := age.Encrypt(dataKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		meta.Age = append(meta.Age, sopsAgeKey{Recipient: recipient.String(), Enc: string(enc)})
+	}
+
+	hash := sha512.New()
+
+	encrypted, err := walkSOPS(tree, nil, func(value interface{}, path []string) (interface{}, error) {
+		hash.Write(sopsBytes(value))
+
+		if value == nil || !meta.encrypted(path) {
+			return value, nil
+		}
+
+		return encryptSOPSValue(value, dataKey, strings.Join(path, ":")+":")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	meta.MAC, err = encryptSOPSValue(fmt.Sprintf("%X", hash.Sum(nil)), dataKey, meta.LastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(append(encrypted.(yaml.MapSlice), yaml.MapItem{Key: sopsKey, Value: meta}))
+}
+
+// parseSOPS returns the values and the metadata of a SOPS file
+func parseSOPS(data []byte) (yaml.MapSlice, *sopsMetadata, error) {
+	var tree yaml.MapSlice
+
+	err := yaml.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var values yaml.MapSlice
+	var meta *sopsMetadata
+
+	for _, item := range tree {
+		if item.Key != sopsKey {
+			values = append(values, item)
+			continue
+		}
+
+		bytes, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		meta = &sopsMetadata{}
+
+		err = yaml.Unmarshal(bytes, meta)
+		if err != nil {
+			return nil, nil, fmt.Errorf("SOPS metadata is malformed: %s", err)
+		}
+	}
+
+	if meta == nil || meta.MAC == "" {
+		return nil, nil, errors.New("Not a SOPS file")
+	}
+
+	return values, meta, nil
+}
+
+// dataKey decrypts the data key of a SOPS file with one of the identities
+func (m *sopsMetadata) dataKey(identities []*age.Identity) ([]byte, error) {
+	if len(m.Age) == 0 {
+		return nil, errors.New("SOPS file has no age recipients")
+	}
+
+	for _, key := range m.Age {
+		dataKey, err := age.Decrypt([]byte(key.Enc), identities...)
+		if err == nil {
+			return dataKey, nil
+		}
+	}
+
+	return nil, errors.New("No identity matched any of the SOPS age recipients")
+}
+
+// encrypted returns true if the value at path is encrypted
+func (m *sopsMetadata) encrypted(path []string) bool {
+	for _, key := range path {
+		switch {
+		case m.UnencryptedSuffix != "" && strings.HasSuffix(key, m.UnencryptedSuffix):
+			return false
+		case m.EncryptedSuffix != "" && strings.HasSuffix(key, m.EncryptedSuffix):
+			return true
+		case m.UnencryptedRegex != "" && matches(m.UnencryptedRegex, key):
+			return false
+		case m.EncryptedRegex != "" && matches(m.EncryptedRegex, key):
+			return true
+		}
+	}
+
+	return m.EncryptedSuffix == "" && m.EncryptedRegex == ""
+}
+
+func matches(pattern string, key string) bool {
+	matched, _ := regexp.MatchString(pattern, key)
+	return matched
+}
+
+// walkSOPS returns a copy of a value with all leaf values replaced by the
+// result of fn. The path of list items is the path of the list
+func walkSOPS(value interface{}, path []string, fn func(value interface{}, path []string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		m := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			p := append(append([]string{}, path...), fmt.Sprint(item.Key))
+
+			r, err := walkSOPS(item.Value, p, fn)
+			if err != nil {
+				return nil, err
+			}
+
+			m[i] = yaml.MapItem{Key: item.Key, Value: r}
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := walkSOPS(item, path, fn)
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = r
+		}
+		return list, nil
+	}
+
+	return fn(value, path)
+}
+
+// sopsBytes returns the bytes of a value that are added to the MAC
+func sopsBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case bool:
+		return []byte(strings.Title(strconv.FormatBool(v)))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	return []byte(fmt.Sprint(value))
+}
+
+func encryptSOPSValue(value interface{}, key []byte, additionalData string) (string, error) {
+	var plaintext, typ string
+
+	switch v := value.(type) {
+	case string:
+		plaintext, typ = v, "str"
+	case int, int64, uint64:
+		plaintext, typ = fmt.Sprint(v), "int"
+	case float64:
+		plaintext, typ = strconv.FormatFloat(v, 'f', -1, 64), "float"
+	case bool:
+		plaintext, typ = strconv.FormatBool(v), "bool"
+	default:
+		return "", fmt.Errorf("Values of type %T can not be encrypted", value)
+	}
+
+	gcm, err := sopsGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, sopsIVSize)
+
+	_, err = rand.Read(iv)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	enc := base64.StdEncoding.EncodeToString
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ), nil
+}
+
+func decryptSOPSValue(value string, key []byte, additionalData string) (interface{}, error) {
+	match := sopsValue.FindStringSubmatch(value)
+	if match == nil {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	var parts [3][]byte
+
+	for i := range parts {
+		var err error
+		parts[i], err = base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			return nil, errors.New("malformed encrypted value")
+		}
+	}
+
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	gcm, err := sopsGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != sopsIVSize || len(tag) != gcm.Overhead() {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, errors.New("authentication failed")
+	}
+
+	switch match[4] {
+	case "str", "bytes":
+		return string(plaintext), nil
+	case "int":
+		return strconv.Atoi(string(plaintext))
+	case "float":
+		return strconv.ParseFloat(string(plaintext), 64)
+	case "bool":
+		return strconv.ParseBool(string(plaintext))
+	}
+
+	return nil, fmt.Errorf("unknown type %s", match[4])
+}
+
+func sopsGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCMWithNonceSize(block, sopsIVSize)
+}
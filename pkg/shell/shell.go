@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// Run runs a shell command with sh in dir and returns its stdout and stderr.
+// The command is killed if ctx is done. Commands can start processes that
+// outlive them, e.g. the processes of a pipeline, so the output pipes are
+// closed without waiting for them
+func Run(ctx context.Context, dir string, command string, stdin io.Reader) ([]byte, []byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+
+	var pipes []*os.File
+	closePipes := func() {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+	}
+
+	pipe := func() (*os.File, *os.File, error) {
+		r, w, err := os.Pipe()
+		if err == nil {
+			pipes = append(pipes, r, w)
+		}
+		return r, w, err
+	}
+
+	defer closePipes()
+
+	stdinR, stdinW, err := pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdoutR, stdoutW, err := pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stderrR, stderrW, err := pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The child has its own copies of its ends of the pipes
+	stdinR.Close()
+	stdoutW.Close()
+	stderrW.Close()
+
+	go func() {
+		if stdin != nil {
+			io.Copy(stdinW, stdin)
+		}
+		stdinW.Close()
+	}()
+
+	stdout := read(stdoutR)
+	stderr := read(stderrR)
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	var waitErr error
+	var stdoutBytes, stderrBytes []byte
+
+	for waited != nil || stdout != nil || stderr != nil {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+			return nil, nil, ctx.Err()
+		case waitErr = <-waited:
+			waited = nil
+		case stdoutBytes = <-stdout:
+			stdout = nil
+		case stderrBytes = <-stderr:
+			stderr = nil
+		}
+	}
+
+	return stdoutBytes, stderrBytes, waitErr
+}
+
+// read reads r to EOF in the background
+func read(r io.Reader) chan []byte {
+	c := make(chan []byte, 1)
+
+	go func() {
+		bytes, _ := ioutil.ReadAll(r)
+		c <- bytes
+	}()
+
+	return c
+}
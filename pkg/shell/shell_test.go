@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	stdout, stderr, err := Run(context.Background(), "/", "pwd; tr a-z A-Z; echo warning >&2", strings.NewReader("manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(stdout) != "/\nMANIFEST" {
+		t.Errorf("expected stdout /\\nMANIFEST, got %q", stdout)
+	}
+
+	if string(stderr) != "warning\n" {
+		t.Errorf("expected stderr warning, got %q", stderr)
+	}
+
+	_, stderr, err = Run(context.Background(), "", "echo failed >&2; exit 3", nil)
+	if err == nil || string(stderr) != "failed\n" {
+		t.Errorf("expected command to fail with stderr, got %v %q", err, stderr)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// The pipeline keeps the output pipes open after sh is killed
+	_, _, err := Run(ctx, "", "sleep 10 | cat", nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the command to be stopped when ctx is done, got %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected Run to return without waiting for the pipeline")
+	}
+}
@@ -0,0 +1,194 @@
+package sign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gonstr/rig/pkg/archive"
+	"github.com/gonstr/rig/pkg/config"
+	"github.com/gonstr/rig/pkg/context"
+	"github.com/gonstr/rig/pkg/fs"
+	"github.com/gonstr/rig/pkg/git"
+	"github.com/gonstr/rig/pkg/metadata"
+)
+
+// FileName is the name of the detached signature file in a template directory
+const FileName = "rig-template.sig"
+
+// Signatures are namespaced so a signature made for one purpose can not be
+// used for another
+const (
+	templateNamespace = "rig-template"
+	gitNamespace      = "git"
+)
+
+// Template signs the template in templateDir with an SSH private key and writes
+// a detached signature file to the template dir. The signature covers the
+// digest of the template dir. The signed digest is returned
+func Template(templateDir string, keyPath string) (string, error) {
+	if !fs.PathExists(path.Join(templateDir, "templates")) {
+		return "", fmt.Errorf("%s is not a template: templates dir is missing", templateDir)
+	}
+
+	keyBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse private key %s: %s", keyPath, err)
+	}
+
+	digest, err := templateDigest(templateDir)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := signSSH(signer, []byte(digest), templateNamespace)
+	if err != nil {
+		return "", err
+	}
+
+	err = ioutil.WriteFile(path.Join(templateDir, FileName), sig, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// Verify verifies that the template of a context is signed by a trusted key.
+// Templates can either be signed by a detached signature file in the template
+// dir or, for templates in git repositories, by an SSH signed git tag. Trusted
+// keys are read from the context and the user config
+func Verify(ctx context.Context, templateDir string) error {
+	trustedKeys, err := trustedKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(trustedKeys) == 0 {
+		return errors.New("Unable to verify template: no trusted keys. Add trustedKeys to the template section of rig.yaml or to ~/.rig/config.yaml")
+	}
+
+	var signer ssh.PublicKey
+
+	sigPath := path.Join(templateDir, FileName)
+
+	if fs.PathExists(sigPath) {
+		signer, err = verifyDetached(templateDir, sigPath)
+	} else if ctx.Scheme() != "" && !ctx.Archive() {
+		signer, err = verifyTag(ctx)
+	} else {
+		err = fmt.Errorf("Template is not signed: %s is missing", FileName)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range trustedKeys {
+		if bytes.Equal(key.Marshal(), signer.Marshal()) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Template is signed by an untrusted key: %s", ssh.FingerprintSHA256(signer))
+}
+
+func verifyDetached(templateDir string, sigPath string) (ssh.PublicKey, error) {
+	sig, err := ioutil.ReadFile(sigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := templateDigest(templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := verifySSH(sig, []byte(digest), templateNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("Template signature does not match the template: %s", err)
+	}
+
+	return signer, nil
+}
+
+// templateDigest returns the digest of the files in a template dir except the
+// signature file. Only the files rig package puts into archives are covered,
+// i.e. hidden dirs are skipped and the digest stamped into the metadata file
+// is left out, so templates stay signed after packaging
+func templateDigest(templateDir string) (string, error) {
+	return fs.DirectoryDigestWith(templateDir, fs.DigestOptions{
+		Skip: func(rel string, info os.FileInfo) bool {
+			return rel == FileName || info.IsDir() && strings.HasPrefix(info.Name(), ".")
+		},
+		Content: func(rel string, content []byte) []byte {
+			if rel == metadata.FileName {
+				return archive.Unstamp(content)
+			}
+			return content
+		},
+	})
+}
+
+func verifyTag(ctx context.Context) (ssh.PublicKey, error) {
+	repoDir, err := ctx.RepoDir()
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := git.Tag(repoDir, ctx.Gitref())
+	if err != nil {
+		return nil, fmt.Errorf("Template is not signed: %s is missing and %s", FileName, err)
+	}
+
+	i := bytes.Index(tag, []byte(sigBegin))
+	if i == -1 {
+		if bytes.Contains(tag, []byte("-----BEGIN PGP SIGNATURE-----")) {
+			return nil, fmt.Errorf("Unable to verify tag %s: only SSH signed tags are supported", ctx.Gitref())
+		}
+
+		return nil, fmt.Errorf("Template is not signed: %s is missing and tag %s is not signed", FileName, ctx.Gitref())
+	}
+
+	signer, err := verifySSH(tag[i:], tag[:i], gitNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to verify tag %s: %s", ctx.Gitref(), err)
+	}
+
+	return signer, nil
+}
+
+func trustedKeys(ctx context.Context) ([]ssh.PublicKey, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	strs = append(strs, ctx.TrustedKeys()...)
+	strs = append(strs, cfg.TrustedKeys...)
+
+	var keys []ssh.PublicKey
+
+	for _, str := range strs {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(str))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse trusted key '%s': %s", str, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,266 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gonstr/rig/pkg/archive"
+	"github.com/gonstr/rig/pkg/context"
+)
+
+// setup creates a template in a temp dir with a temp home dir and writes a
+// generated ed25519 key. The template dir, the key path and the public key are
+// returned
+func setup(t *testing.T) (string, string, ssh.PublicKey) {
+	dir, err := ioutil.TempDir("", "rig-sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", path.Join(dir, "home"))
+
+	templateDir := path.Join(dir, "template")
+
+	err = os.MkdirAll(path.Join(templateDir, "templates"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, path.Join(templateDir, "templates", "service.yaml"), "kind: Service\n")
+	write(t, path.Join(templateDir, "values.yaml"), "port: 80\n")
+	write(t, path.Join(templateDir, "rig-template.yaml"), "name: simple-app\nversion: 1.0.0\n")
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := path.Join(dir, "key")
+	write(t, keyPath, marshalPrivateKey(sshKey, privateKey))
+
+	return templateDir, keyPath, sshKey
+}
+
+// marshalPrivateKey returns an unencrypted ed25519 private key in the OpenSSH
+// format that ssh-keygen writes
+func marshalPrivateKey(publicKey ssh.PublicKey, privateKey ed25519.PrivateKey) string {
+	block := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Type    string
+		Public  []byte
+		Private []byte
+		Comment string
+	}{1, 1, ssh.KeyAlgoED25519, privateKey.Public().(ed25519.PublicKey), privateKey, ""})
+
+	for i := byte(1); len(block)%8 != 0; i++ {
+		block = append(block, i)
+	}
+
+	key := ssh.Marshal(struct {
+		Cipher  string
+		KDF     string
+		Options string
+		N       uint32
+		Public  []byte
+		Private []byte
+	}{"none", "none", "", 1, publicKey.Marshal(), block})
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), key...),
+	}))
+}
+
+func write(t *testing.T, filePath string, content string) {
+	err := ioutil.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func templateContext(t *testing.T, templateDir string, trustedKeys ...ssh.PublicKey) context.Context {
+	var keys []interface{}
+	for _, key := range trustedKeys {
+		keys = append(keys, string(ssh.MarshalAuthorizedKey(key)))
+	}
+
+	ctx, err := context.FromMap(map[string]interface{}{
+		"template": map[string]interface{}{
+			"path":        templateDir,
+			"trustedKeys": keys,
+		},
+	}, path.Join(path.Dir(templateDir), "rig.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ctx
+}
+
+func TestTemplate(t *testing.T) {
+	templateDir, keyPath, publicKey := setup(t)
+	defer os.RemoveAll(path.Dir(templateDir))
+
+	_, err := Template(templateDir, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(templateContext(t, templateDir, publicKey), templateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(templateContext(t, templateDir, otherKey), templateDir)
+	if err == nil || !strings.Contains(err.Error(), "untrusted key") {
+		t.Errorf("expected a template signed by an untrusted key to fail, got %v", err)
+	}
+}
+
+func TestTemplateTampered(t *testing.T) {
+	for _, file := range []string{"templates/service.yaml", "values.yaml", "patch.yaml"} {
+		templateDir, keyPath, publicKey := setup(t)
+		defer os.RemoveAll(path.Dir(templateDir))
+
+		_, err := Template(templateDir, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		write(t, path.Join(templateDir, file), "tampered: true\n")
+
+		err = Verify(templateContext(t, templateDir, publicKey), templateDir)
+		if err == nil || !strings.Contains(err.Error(), "does not match") {
+			t.Errorf("expected changing %s to fail verification, got %v", file, err)
+		}
+	}
+}
+
+func TestTemplatePackaged(t *testing.T) {
+	templateDir, keyPath, publicKey := setup(t)
+	defer os.RemoveAll(path.Dir(templateDir))
+
+	err := os.MkdirAll(path.Join(templateDir, ".github"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, path.Join(templateDir, ".github", "CODEOWNERS"), "* @platform\n")
+
+	_, err = Template(templateDir, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath, _, err := archive.Package(templateDir, path.Join(path.Dir(templateDir), "dist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extractedDir, err := archive.Extract(archivePath, path.Join(path.Dir(templateDir), "extracted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stamped, err := ioutil.ReadFile(path.Join(extractedDir, "rig-template.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(stamped), "digest: ") {
+		t.Fatal("expected the packaged metadata file to be stamped with a digest")
+	}
+
+	err = Verify(templateContext(t, extractedDir, publicKey), extractedDir)
+	if err != nil {
+		t.Errorf("expected the packaged template to verify: %s", err)
+	}
+}
+
+func TestSSHRoundTrip(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("message")
+
+	sig, err := signSSH(signer, message, templateNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := verifySSH(sig, message, templateNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+		t.Error("expected the signer public key")
+	}
+
+	_, err = verifySSH(sig, []byte("tampered"), templateNamespace)
+	if err == nil {
+		t.Error("expected a tampered message to fail verification")
+	}
+
+	_, err = verifySSH(sig, message, gitNamespace)
+	if err == nil {
+		t.Error("expected a signature of another namespace to fail verification")
+	}
+
+	// Signatures are compatible with ssh-keygen
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "rig-sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sigPath := path.Join(dir, "message.sig")
+	write(t, sigPath, string(sig))
+
+	cmd := exec.Command("ssh-keygen", "-Y", "check-novalidate", "-n", templateNamespace, "-s", sigPath)
+	cmd.Stdin = bytes.NewReader(message)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("expected ssh-keygen to verify the signature: %s", out)
+	}
+}
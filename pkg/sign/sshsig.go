@@ -0,0 +1,189 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH signatures are encoded as described in PROTOCOL.sshsig of OpenSSH which
+// makes them compatible with ssh-keygen -Y and SSH signed git tags
+
+const (
+	sigMagic   = "SSHSIG"
+	sigVersion = 1
+	sigHashAlg = "sha512"
+	sigBegin   = "-----BEGIN SSH SIGNATURE-----"
+	sigEnd     = "-----END SSH SIGNATURE-----"
+)
+
+type sshSignature struct {
+	publicKey ssh.PublicKey
+	namespace string
+	hashAlg   string
+	signature *ssh.Signature
+}
+
+// signedData returns the data that is signed for a message
+func signedData(message []byte, namespace string, hashAlg string) ([]byte, error) {
+	if hashAlg != sigHashAlg && hashAlg != "sha256" {
+		return nil, fmt.Errorf("Unsupported signature hash algorithm: %s", hashAlg)
+	}
+
+	var hash []byte
+	if hashAlg == "sha256" {
+		h := sha256.Sum256(message)
+		hash = h[:]
+	} else {
+		h := sha512.Sum512(message)
+		hash = h[:]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(sigMagic)
+	writeString(&buf, []byte(namespace))
+	writeString(&buf, nil)
+	writeString(&buf, []byte(hashAlg))
+	writeString(&buf, hash)
+
+	return buf.Bytes(), nil
+}
+
+// signSSH signs a message and returns an armored SSH signature
+func signSSH(signer ssh.Signer, message []byte, namespace string) ([]byte, error) {
+	data, err := signedData(message, namespace, sigHashAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(sigMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(sigVersion))
+	writeString(&buf, signer.PublicKey().Marshal())
+	writeString(&buf, []byte(namespace))
+	writeString(&buf, nil)
+	writeString(&buf, []byte(sigHashAlg))
+	writeString(&buf, ssh.Marshal(sig))
+
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	var armored strings.Builder
+	armored.WriteString(sigBegin + "\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString(sigEnd + "\n")
+
+	return []byte(armored.String()), nil
+}
+
+// parseSSH parses an armored SSH signature
+func parseSSH(armored []byte) (*sshSignature, error) {
+	str := strings.TrimSpace(string(armored))
+
+	if !strings.HasPrefix(str, sigBegin) || !strings.HasSuffix(str, sigEnd) {
+		return nil, errors.New("Invalid signature: not an SSH signature")
+	}
+
+	str = strings.TrimSuffix(strings.TrimPrefix(str, sigBegin), sigEnd)
+	str = strings.Join(strings.Fields(str), "")
+
+	blob, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signature: %s", err)
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sigMagic)) {
+		return nil, errors.New("Invalid signature: bad magic")
+	}
+	blob = blob[len(sigMagic):]
+
+	if len(blob) < 4 || binary.BigEndian.Uint32(blob) != sigVersion {
+		return nil, errors.New("Invalid signature: unsupported version")
+	}
+	blob = blob[4:]
+
+	var fields [5][]byte
+	for i := range fields {
+		fields[i], blob, err = readString(blob)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	publicKey, err := ssh.ParsePublicKey(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signature: %s", err)
+	}
+
+	var sig ssh.Signature
+	err = ssh.Unmarshal(fields[4], &sig)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signature: %s", err)
+	}
+
+	return &sshSignature{
+		publicKey: publicKey,
+		namespace: string(fields[1]),
+		hashAlg:   string(fields[3]),
+		signature: &sig,
+	}, nil
+}
+
+// verifySSH verifies an armored SSH signature of a message. The public key of
+// the signer is returned if the signature is valid
+func verifySSH(armored []byte, message []byte, namespace string) (ssh.PublicKey, error) {
+	sig, err := parseSSH(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	if sig.namespace != namespace {
+		return nil, fmt.Errorf("Invalid signature: expected namespace %s but got %s", namespace, sig.namespace)
+	}
+
+	data, err := signedData(message, sig.namespace, sig.hashAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sig.publicKey.Verify(data, sig.signature)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signature: %s", err)
+	}
+
+	return sig.publicKey, nil
+}
+
+func writeString(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+func readString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("Invalid signature: unexpected end of data")
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return nil, nil, errors.New("Invalid signature: unexpected end of data")
+	}
+
+	return b[4 : 4+n], b[4+n:], nil
+}
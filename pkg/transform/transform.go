@@ -0,0 +1,212 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gonstr/rig/pkg/manifest"
+)
+
+// Options are the common settings of rig.yaml that are applied to all rendered
+// objects
+type Options struct {
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+	// CommonLabelSelectors adds common labels to selectors when true or unset.
+	// Selectors of most workloads are immutable so changing common labels of
+	// deployed objects fails unless this is false
+	CommonLabelSelectors *bool             `json:"commonLabelSelectors,omitempty"`
+	CommonAnnotations    map[string]string `json:"commonAnnotations,omitempty"`
+	Namespace            string            `json:"namespace,omitempty"`
+	// ClusterScopedKinds are kinds, e.g. of custom resources, that are not
+	// namespaced in addition to the built-in cluster scoped kinds
+	ClusterScopedKinds []string `json:"clusterScopedKinds,omitempty"`
+}
+
+// fieldSpec is the path of a label or annotation map in objects of a kind. An
+// empty kind matches all kinds. Maps are only created if create is true.
+// Selectors are marked so they can be left out
+type fieldSpec struct {
+	kind     string
+	path     []string
+	create   bool
+	selector bool
+}
+
+// labelFields are the label maps common labels are added to, in addition to
+// the metadata of all objects. Pod templates get the labels too. Selectors are
+// only extended if they exist since adding a selector changes which pods an
+// object selects
+var labelFields = []fieldSpec{
+	{"Service", []string{"spec", "selector"}, false, true},
+	{"ReplicationController", []string{"spec", "selector"}, false, true},
+	{"ReplicationController", []string{"spec", "template", "metadata", "labels"}, true, false},
+	{"Deployment", []string{"spec", "selector", "matchLabels"}, false, true},
+	{"Deployment", []string{"spec", "template", "metadata", "labels"}, true, false},
+	{"ReplicaSet", []string{"spec", "selector", "matchLabels"}, false, true},
+	{"ReplicaSet", []string{"spec", "template", "metadata", "labels"}, true, false},
+	{"DaemonSet", []string{"spec", "selector", "matchLabels"}, false, true},
+	{"DaemonSet", []string{"spec", "template", "metadata", "labels"}, true, false},
+	{"StatefulSet", []string{"spec", "selector", "matchLabels"}, false, true},
+	{"StatefulSet", []string{"spec", "template", "metadata", "labels"}, true, false},
+	{"Job", []string{"spec", "selector", "matchLabels"}, false, true},
+	{"Job", []string{"spec", "template", "metadata", "labels"}, true, false},
+	{"CronJob", []string{"spec", "jobTemplate", "metadata", "labels"}, true, false},
+	{"CronJob", []string{"spec", "jobTemplate", "spec", "selector", "matchLabels"}, false, true},
+	{"CronJob", []string{"spec", "jobTemplate", "spec", "template", "metadata", "labels"}, true, false},
+	{"PodDisruptionBudget", []string{"spec", "selector", "matchLabels"}, false, true},
+	{"NetworkPolicy", []string{"spec", "podSelector", "matchLabels"}, false, true},
+}
+
+// annotationFields are the annotation maps common annotations are added to,
+// in addition to the metadata of all objects
+var annotationFields = []fieldSpec{
+	{"ReplicationController", []string{"spec", "template", "metadata", "annotations"}, true, false},
+	{"Deployment", []string{"spec", "template", "metadata", "annotations"}, true, false},
+	{"ReplicaSet", []string{"spec", "template", "metadata", "annotations"}, true, false},
+	{"DaemonSet", []string{"spec", "template", "metadata", "annotations"}, true, false},
+	{"StatefulSet", []string{"spec", "template", "metadata", "annotations"}, true, false},
+	{"Job", []string{"spec", "template", "metadata", "annotations"}, true, false},
+	{"CronJob", []string{"spec", "jobTemplate", "metadata", "annotations"}, true, false},
+	{"CronJob", []string{"spec", "jobTemplate", "spec", "template", "metadata", "annotations"}, true, false},
+}
+
+// clusterScoped are the built-in kinds that are not namespaced. Other kinds,
+// including custom resources, are expected to be namespaced unless they are
+// listed in clusterScopedKinds in rig.yaml
+var clusterScoped = map[string]bool{
+	"APIService":                     true,
+	"CertificateSigningRequest":      true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"ComponentStatus":                true,
+	"CSIDriver":                      true,
+	"CSINode":                        true,
+	"CustomResourceDefinition":       true,
+	"IngressClass":                   true,
+	"MutatingWebhookConfiguration":   true,
+	"Namespace":                      true,
+	"Node":                           true,
+	"PersistentVolume":               true,
+	"PodSecurityPolicy":              true,
+	"PriorityClass":                  true,
+	"RuntimeClass":                   true,
+	"StorageClass":                   true,
+	"ValidatingWebhookConfiguration": true,
+	"VolumeAttachment":               true,
+}
+
+// FromMap returns the common settings of the parsed contents of a rig file.
+// The file path is only used in error messages
+func FromMap(file map[string]interface{}, filePath string) (*Options, error) {
+	bytes, err := json.Marshal(map[string]interface{}{
+		"commonLabels":         file["commonLabels"],
+		"commonLabelSelectors": file["commonLabelSelectors"],
+		"commonAnnotations":    file["commonAnnotations"],
+		"namespace":            file["namespace"],
+		"clusterScopedKinds":   file["clusterScopedKinds"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var opts Options
+
+	err = json.Unmarshal(bytes, &opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s is malformed: commonLabels and commonAnnotations must be maps of strings, commonLabelSelectors a bool, namespace a string and clusterScopedKinds a list of strings: %s", filePath, err)
+	}
+
+	return &opts, nil
+}
+
+// Empty returns true if there is nothing to apply
+func (o *Options) Empty() bool {
+	return len(o.CommonLabels) == 0 && len(o.CommonAnnotations) == 0 && o.Namespace == ""
+}
+
+// Apply applies the common settings to all objects of a manifest. The manifest
+// is returned as is if there is nothing to apply
+func Apply(opts *Options, str string) (string, error) {
+	if opts.Empty() {
+		return str, nil
+	}
+
+	objs, err := manifest.Parse(str)
+	if err != nil {
+		return "", err
+	}
+
+	for _, obj := range objs {
+		kind := manifest.Kind(obj)
+
+		if len(opts.CommonLabels) > 0 {
+			set(manifest.Metadata(obj), []string{"labels"}, true, opts.CommonLabels)
+
+			for _, field := range labelFields {
+				if field.kind == kind && (!field.selector || opts.selectors()) {
+					set(obj, field.path, field.create, opts.CommonLabels)
+				}
+			}
+		}
+
+		if len(opts.CommonAnnotations) > 0 {
+			set(manifest.Metadata(obj), []string{"annotations"}, true, opts.CommonAnnotations)
+
+			for _, field := range annotationFields {
+				if field.kind == kind {
+					set(obj, field.path, field.create, opts.CommonAnnotations)
+				}
+			}
+		}
+
+		if opts.Namespace != "" && !opts.clusterScoped(kind) {
+			manifest.Metadata(obj)["namespace"] = opts.Namespace
+		}
+	}
+
+	return manifest.String(objs)
+}
+
+// selectors returns true if common labels are added to selectors
+func (o *Options) selectors() bool {
+	return o.CommonLabelSelectors == nil || *o.CommonLabelSelectors
+}
+
+// clusterScoped returns true if objects of a kind are not namespaced
+func (o *Options) clusterScoped(kind string) bool {
+	if clusterScoped[kind] {
+		return true
+	}
+
+	for _, k := range o.ClusterScopedKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// set adds values to the map at a path in an object. Missing maps along the
+// path are created if create is true, otherwise nothing is set
+func set(obj map[string]interface{}, path []string, create bool, values map[string]string) {
+	m := obj
+
+	for _, key := range path {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			if !create || m[key] != nil {
+				return
+			}
+
+			next = make(map[string]interface{})
+			m[key] = next
+		}
+
+		m = next
+	}
+
+	for k, v := range values {
+		m[k] = v
+	}
+}
@@ -0,0 +1,290 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+)
+
+const objects = `apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: app`
+
+func TestNamespace(t *testing.T) {
+	opts, err := FromMap(map[string]interface{}{
+		"namespace":          "my-app",
+		"clusterScopedKinds": []interface{}{"ClusterIssuer"},
+	}, "rig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Apply(opts, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: my-app
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app
+---
+apiVersion: cert-manager.io/v1
+kind: ClusterIssuer
+metadata:
+  name: app`
+
+	if out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+}
+
+func TestFromMapMalformed(t *testing.T) {
+	for _, file := range []map[string]interface{}{
+		{"clusterScopedKinds": "ClusterIssuer"},
+		{"commonLabels": []interface{}{"team"}},
+		{"commonLabels": map[string]interface{}{"replicas": map[string]interface{}{"a": "b"}}},
+		{"commonAnnotations": "owner"},
+		{"commonLabelSelectors": "no"},
+		{"namespace": []interface{}{"a"}},
+	} {
+		_, err := FromMap(file, "rig.yaml")
+		if err == nil || !strings.Contains(err.Error(), "rig.yaml is malformed") {
+			t.Errorf("expected %v to fail, got %v", file, err)
+		}
+	}
+}
+
+const workloads = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  selector:
+    app: app
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: app
+spec:
+  selector: null`
+
+func TestCommonLabels(t *testing.T) {
+	opts, err := FromMap(map[string]interface{}{
+		"commonLabels":      map[string]interface{}{"team": "platform"},
+		"commonAnnotations": map[string]interface{}{"owner": "platform@example.com"},
+	}, "rig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Apply(opts, workloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing selectors are not created and selectors that are not maps are
+	// left as they are
+	expected := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    owner: platform@example.com
+  labels:
+    team: platform
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+      team: platform
+  template:
+    metadata:
+      annotations:
+        owner: platform@example.com
+      labels:
+        app: app
+        team: platform
+---
+apiVersion: v1
+kind: Service
+metadata:
+  annotations:
+    owner: platform@example.com
+  labels:
+    team: platform
+  name: app
+spec:
+  selector:
+    app: app
+    team: platform
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  annotations:
+    owner: platform@example.com
+  labels:
+    team: platform
+  name: migrate
+spec:
+  template:
+    metadata:
+      annotations:
+        owner: platform@example.com
+      labels:
+        team: platform
+    spec:
+      restartPolicy: Never
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  annotations:
+    owner: platform@example.com
+  labels:
+    team: platform
+  name: app
+spec:
+  selector: null`
+
+	if out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+}
+
+func TestCommonLabelsWithoutSelectors(t *testing.T) {
+	opts, err := FromMap(map[string]interface{}{
+		"commonLabels":         map[string]interface{}{"team": "platform"},
+		"commonLabelSelectors": false,
+	}, "rig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Apply(opts, workloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    team: platform
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+        team: platform
+---
+apiVersion: v1
+kind: Service
+metadata:
+  labels:
+    team: platform
+  name: app
+spec:
+  selector:
+    app: app
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  labels:
+    team: platform
+  name: migrate
+spec:
+  template:
+    metadata:
+      labels:
+        team: platform
+    spec:
+      restartPolicy: Never
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  labels:
+    team: platform
+  name: app
+spec:
+  selector: null`
+
+	if out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+}
+
+func TestApplyEmpty(t *testing.T) {
+	opts, err := FromMap(map[string]interface{}{}, "rig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is parsed when there is nothing to apply
+	out, err := Apply(opts, "not: [yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "not: [yaml" {
+		t.Errorf("expected the manifest to be returned as is, got %s", out)
+	}
+}
+
+func TestApplyInvalidManifest(t *testing.T) {
+	opts, err := FromMap(map[string]interface{}{"namespace": "my-app"}, "rig.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Apply(opts, "kind: Service\nmetadata: [")
+	if err == nil {
+		t.Error("expected an invalid manifest to fail")
+	}
+}
@@ -0,0 +1,118 @@
+package valueref
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/gonstr/rig/pkg/shell"
+)
+
+// resolveEnv resolves ref+env://NAME to the value of an environment variable
+func resolveEnv(ctx context.Context, ref Ref) (interface{}, error) {
+	value, ok := os.LookupEnv(ref.Path)
+	if !ok {
+		return nil, fmt.Errorf("Environment variable '%s' is not set", ref.Path)
+	}
+
+	return value, nil
+}
+
+// resolveFile resolves ref+file://path to the trimmed contents of a file, or
+// ref+file://path#key to a key of a yaml or json file
+func resolveFile(ctx context.Context, ref Ref) (interface{}, error) {
+	filePath := ref.Path
+	if !path.IsAbs(filePath) {
+		filePath = path.Join(ref.Dir, filePath)
+	}
+
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookup(bytes, ref.Key)
+}
+
+// resolveExec resolves ref+exec://command to the trimmed output of a shell
+// command run in the rig.yaml dir, or ref+exec://command#key to a key of its
+// yaml or json output. The command is killed if ctx is done
+func resolveExec(ctx context.Context, ref Ref) (interface{}, error) {
+	out, stderr, err := shell.Run(ctx, ref.Dir, ref.Path, nil)
+	if err != nil {
+		if msg := strings.TrimSpace(string(stderr)); msg != "" {
+			return nil, fmt.Errorf("'%s' failed: %s\n%s", ref.Path, err, msg)
+		}
+		return nil, fmt.Errorf("'%s' failed: %s", ref.Path, err)
+	}
+
+	return lookup(out, ref.Key)
+}
+
+// lookup returns the trimmed content if key is empty. Otherwise the content is
+// parsed as yaml or json and the value of the dot separated key is returned
+func lookup(content []byte, key string) (interface{}, error) {
+	if key == "" {
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	var doc map[string]interface{}
+
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse content to look up key '%s': %s", key, err)
+	}
+
+	return Static(doc).get(key)
+}
+
+// Static is a provider that resolves references from a map. Keys are the path
+// of references and, for references with a key, the dot separated key of a
+// nested map. It stands in for remote secret stores, e.g.
+//
+//	valueref.Register("vault", valueref.Static{"secret/db": map[string]interface{}{"password": "pw"}})
+//
+// resolves ref+vault://secret/db#password to pw
+type Static map[string]interface{}
+
+// Resolve resolves a reference from the map
+func (s Static) Resolve(ctx context.Context, ref Ref) (interface{}, error) {
+	value, ok := s[ref.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist", ref.Path)
+	}
+
+	if ref.Key == "" {
+		return value, nil
+	}
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no key '%s'", ref.Path, ref.Key)
+	}
+
+	return Static(m).get(ref.Key)
+}
+
+func (s Static) get(key string) (interface{}, error) {
+	var value interface{} = map[string]interface{}(s)
+
+	for _, k := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("key '%s' does not exist", key)
+		}
+
+		value, ok = m[k]
+		if !ok {
+			return nil, fmt.Errorf("key '%s' does not exist", key)
+		}
+	}
+
+	return value, nil
+}
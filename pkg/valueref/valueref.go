@@ -0,0 +1,204 @@
+package valueref
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prefix is the prefix of value references, e.g. ref+env://DB_HOST
+const Prefix = "ref+"
+
+// Ref is a parsed value reference, ref+<scheme>://<path>[#<key>]
+type Ref struct {
+	Scheme string
+	Path   string
+	Key    string
+	// Dir is the dir relative paths are resolved against
+	Dir string
+}
+
+func (r Ref) String() string {
+	s := fmt.Sprintf("%s%s://%s", Prefix, r.Scheme, r.Path)
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// Provider resolves value references of a scheme. Providers should stop
+// resolving when ctx is done
+type Provider interface {
+	Resolve(ctx context.Context, ref Ref) (interface{}, error)
+}
+
+// ProviderFunc is a function that implements Provider
+type ProviderFunc func(ctx context.Context, ref Ref) (interface{}, error)
+
+// Resolve calls f(ctx, ref)
+func (f ProviderFunc) Resolve(ctx context.Context, ref Ref) (interface{}, error) {
+	return f(ctx, ref)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+func init() {
+	Register("env", ProviderFunc(resolveEnv))
+	Register("file", ProviderFunc(resolveFile))
+	Register("exec", ProviderFunc(resolveExec))
+}
+
+// Register registers the provider of a scheme. Registering a scheme again
+// replaces its provider
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers[scheme] = p
+}
+
+// Schemes returns the registered schemes in order
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var schemes []string
+	for scheme := range providers {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// IsRef returns true if a value is a reference
+func IsRef(value interface{}) bool {
+	str, ok := value.(string)
+	return ok && strings.HasPrefix(str, Prefix)
+}
+
+// Parse parses a value reference. Relative paths are resolved against dir
+func Parse(str string, dir string) (Ref, error) {
+	if !strings.HasPrefix(str, Prefix) {
+		return Ref{}, fmt.Errorf("%s is not a value reference", str)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(str, Prefix), "://", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Ref{}, fmt.Errorf("%s is malformed, expected %s<scheme>://<path>[#<key>]", str, Prefix)
+	}
+
+	ref := Ref{Scheme: parts[0], Path: parts[1], Dir: dir}
+
+	if i := strings.LastIndex(ref.Path, "#"); i != -1 {
+		ref.Key = ref.Path[i+1:]
+		ref.Path = ref.Path[:i]
+	}
+
+	return ref, nil
+}
+
+// Resolve returns a copy of vals with all value references, strings prefixed
+// with ref+, replaced by the values their providers resolve them to. Resolving
+// is stopped if ctx is done
+func Resolve(ctx context.Context, vals map[string]interface{}, dir string) (map[string]interface{}, error) {
+	resolved, err := walk(vals, "", func(key string, str string) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		r, err := resolveRef(ctx, str, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve value %s: %s", key, err)
+		}
+		return r, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved.(map[string]interface{}), nil
+}
+
+// Find returns the value references of vals by their dot separated key
+func Find(vals map[string]interface{}) (map[string]Ref, error) {
+	refs := map[string]Ref{}
+
+	_, err := walk(vals, "", func(key string, str string) (interface{}, error) {
+		ref, err := Parse(str, "")
+		if err != nil {
+			return nil, err
+		}
+		refs[key] = ref
+		return str, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// walk returns a copy of value with all references replaced by the result of
+// fn
+func walk(value interface{}, key string, fn func(key string, str string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			r, err := walk(item, join(key, k), fn)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = r
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := walk(item, fmt.Sprintf("%s[%d]", key, i), fn)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = r
+		}
+		return list, nil
+	case string:
+		if !IsRef(v) {
+			return v, nil
+		}
+		return fn(key, v)
+	}
+
+	return value, nil
+}
+
+func resolveRef(ctx context.Context, str string, dir string) (interface{}, error) {
+	ref, err := Parse(str, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	p, ok := providers[ref.Scheme]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%s has an unknown scheme '%s', expected one of %s", str, ref.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	return p.Resolve(ctx, ref)
+}
+
+func join(key, k string) string {
+	if key == "" {
+		return k
+	}
+	return key + "." + k
+}
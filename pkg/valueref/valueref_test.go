@@ -0,0 +1,121 @@
+package valueref
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveStatic(t *testing.T) {
+	Register("static", Static{
+		"secret/db": map[string]interface{}{
+			"user": "app",
+			"auth": map[string]interface{}{"password": "pw"},
+		},
+		"token": "abc",
+	})
+
+	vals := map[string]interface{}{
+		"db": map[string]interface{}{
+			"user":     "ref+static://secret/db#user",
+			"password": "ref+static://secret/db#auth.password",
+		},
+		"tokens": []interface{}{"ref+static://token", "plain"},
+		"port":   8080,
+	}
+
+	resolved, err := Resolve(context.Background(), vals, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"db": map[string]interface{}{
+			"user":     "app",
+			"password": "pw",
+		},
+		"tokens": []interface{}{"abc", "plain"},
+		"port":   8080,
+	}
+
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("expected %v, got %v", expected, resolved)
+	}
+
+	if vals["tokens"].([]interface{})[0] != "ref+static://token" {
+		t.Error("expected vals to be left unchanged")
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	Register("static", Static{"secret/db": map[string]interface{}{"user": "app"}})
+
+	for _, ref := range []string{
+		"ref+static://secret/missing",
+		"ref+static://secret/db#password",
+		"ref+unknown://secret/db",
+		"ref+static://",
+	} {
+		_, err := Resolve(context.Background(), map[string]interface{}{"value": ref}, "")
+		if err == nil {
+			t.Errorf("expected %s to fail", ref)
+		}
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	os.Setenv("RIG_TEST_EMPTY", "")
+	os.Unsetenv("RIG_TEST_UNSET")
+
+	resolved, err := Resolve(context.Background(), map[string]interface{}{"value": "ref+env://RIG_TEST_EMPTY"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved["value"] != "" {
+		t.Errorf("expected empty value, got %v", resolved["value"])
+	}
+
+	_, err = Resolve(context.Background(), map[string]interface{}{"value": "ref+env://RIG_TEST_UNSET"}, "")
+	if err == nil {
+		t.Error("expected unset variable to fail")
+	}
+}
+
+func TestFind(t *testing.T) {
+	refs, err := Find(map[string]interface{}{
+		"db":   map[string]interface{}{"host": "ref+env://DB_HOST"},
+		"list": []interface{}{"ref+exec://echo hi#key"},
+		"name": "app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Ref{
+		"db.host": {Scheme: "env", Path: "DB_HOST"},
+		"list[0]": {Scheme: "exec", Path: "echo hi", Key: "key"},
+	}
+
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("expected %v, got %v", expected, refs)
+	}
+}
+
+func TestResolveExecCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := Resolve(ctx, map[string]interface{}{"value": "ref+exec://sleep 10"}, "")
+	if err == nil {
+		t.Fatal("expected resolving to fail when ctx is done")
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected the command to be killed when ctx is done")
+	}
+}
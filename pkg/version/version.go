@@ -0,0 +1,4 @@
+package version
+
+// Version is the current version of rig
+const Version = "0.3.4"
@@ -0,0 +1,87 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Interval is the default poll interval
+const Interval = 500 * time.Millisecond
+
+// Poll calls fn whenever a file in one of the watched paths is created,
+// changed or removed. Dirs are watched recursively. The paths func is called
+// on every poll so the watched paths can change over time. fn is only called
+// once the files have not changed for an interval so files that are being
+// written are not read. Poll returns when stop is closed
+func Poll(paths func() []string, interval time.Duration, stop <-chan struct{}, fn func()) {
+	last := state(paths())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := state(paths())
+		if current == last {
+			continue
+		}
+
+		for settled := false; !settled; {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			next := state(paths())
+			settled = next == current
+			current = next
+		}
+
+		last = current
+		fn()
+	}
+}
+
+// state returns a string describing the size, mode and modification time of
+// all files in paths
+func state(paths []string) string {
+	var lines []string
+
+	for _, p := range paths {
+		err := filepath.Walk(p, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if filePath != p && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			lines = append(lines, fmt.Sprintf("%s %d %s %d", filePath, info.Size(), info.Mode(), info.ModTime().UnixNano()))
+
+			return nil
+		})
+
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s %s", p, err))
+		}
+	}
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,99 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const interval = 10 * time.Millisecond
+
+func write(t *testing.T, filePath string, content string) {
+	err := os.MkdirAll(path.Dir(filePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect waits for a change to be reported if changed is true, or makes sure
+// no change is reported for a few intervals
+func expect(t *testing.T, changes <-chan struct{}, changed bool, name string) {
+	select {
+	case <-changes:
+		if !changed {
+			t.Errorf("%s: expected no change to be reported", name)
+		}
+	case <-time.After(10 * interval):
+		if changed {
+			t.Errorf("%s: expected a change to be reported", name)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rig-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	template := path.Join(dir, "template")
+	rigFile := path.Join(dir, "rig.yaml")
+
+	write(t, path.Join(template, "templates", "service.yaml"), "kind: Service\n")
+	write(t, rigFile, "template:\n  path: ./template\n")
+
+	watched := []string{rigFile, template}
+
+	changes := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		Poll(func() []string { return watched }, interval, stop, func() {
+			changes <- struct{}{}
+		})
+		close(done)
+	}()
+
+	expect(t, changes, false, "no change")
+
+	write(t, path.Join(template, "templates", "service.yaml"), "kind: Service\nport: 80\n")
+	expect(t, changes, true, "changed file")
+
+	write(t, path.Join(template, "templates", "nested", "deployment.yaml"), "kind: Deployment\n")
+	expect(t, changes, true, "created file in a nested dir")
+
+	err = os.Remove(path.Join(template, "templates", "nested", "deployment.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, changes, true, "removed file")
+
+	err = os.Chmod(rigFile, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, changes, true, "changed mode")
+
+	write(t, path.Join(template, ".git", "index"), "index")
+	expect(t, changes, false, "file in a hidden dir")
+
+	write(t, path.Join(dir, "other.yaml"), "kind: Other\n")
+	expect(t, changes, false, "file outside the watched paths")
+
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Poll to return when stop is closed")
+	}
+}